@@ -2,6 +2,8 @@ package bridge
 
 import (
 	"time"
+
+	"github.com/spf13/viper"
 )
 
 type Bridger interface {
@@ -59,6 +61,93 @@ type Bridger interface {
 	GetLastSentMsgs() []string
 }
 
+// SessionKeeper is implemented by bridges whose session can be reused for a later login
+// with Credentials.SessionToken.
+type SessionKeeper interface {
+	// SessionToken returns the token of the current session.
+	SessionToken() string
+	// KeepSession leaves the session on the server when logging out, its token is stored.
+	KeepSession()
+	// RevokeSession ends the current session on the server.
+	RevokeSession() error
+}
+
+// ConfigUpdater is implemented by bridges that read their config after logging in.
+type ConfigUpdater interface {
+	// UpdateConfig replaces the config, it's never changed in place.
+	UpdateConfig(v *viper.Viper)
+}
+
+// ThreadKeeper is implemented by bridges with collapsed reply threads, where threads are
+// followed and have their own read state.
+type ThreadKeeper interface {
+	// GetThreads returns the threads we follow, only the unread ones with unreadOnly.
+	GetThreads(unreadOnly bool) ([]*ThreadInfo, error)
+	// FollowThread follows or unfollows the thread of rootID.
+	FollowThread(rootID string, follow bool) error
+	// UpdateThreadRead marks the thread of rootID as read.
+	UpdateThreadRead(rootID string) error
+}
+
+// ThreadInfo is a thread we follow.
+type ThreadInfo struct {
+	RootID         string
+	ChannelID      string
+	UserID         string
+	Message        string
+	ReplyCount     int64
+	LastReplyAt    int64
+	UnreadReplies  int64
+	UnreadMentions int64
+}
+
+// UnreadKeeper is implemented by bridges that know how much we haven't read on the server.
+type UnreadKeeper interface {
+	// GetUnread returns the channels with unread messages or mentions.
+	GetUnread() ([]*UnreadInfo, error)
+	// MarkAllRead marks all channels (and threads) as read, it returns the channels it marked.
+	MarkAllRead() ([]string, error)
+}
+
+// ChannelNotifier is implemented by bridges with notification preferences per channel.
+type ChannelNotifier interface {
+	// GetChannelNotify returns the preferences of our channels by channel ID.
+	GetChannelNotify() (map[string]*ChannelNotify, error)
+	// MuteChannel mutes or unmutes channelID.
+	MuteChannel(channelID string, mute bool) error
+}
+
+// ChannelNotify are our notification preferences of a channel.
+type ChannelNotify struct {
+	Muted bool
+	// Desktop is what we're notified of: "all", "mention", "none" or "" for our default.
+	Desktop               string
+	IgnoreChannelMentions bool
+}
+
+// CategoryKeeper is implemented by bridges where we organise channels in (sidebar) categories.
+type CategoryKeeper interface {
+	// GetCategories returns our categories of all teams, in their order.
+	GetCategories() ([]*CategoryInfo, error)
+	// MoveChannel moves channelID to our category named category.
+	MoveChannel(channelID, category string) error
+}
+
+// CategoryInfo is a category with its channels, in their order.
+type CategoryInfo struct {
+	ID         string
+	TeamID     string
+	Name       string
+	ChannelIDs []string
+}
+
+// UnreadInfo is what we haven't read of a channel.
+type UnreadInfo struct {
+	ChannelID string
+	Messages  int64
+	Mentions  int64
+}
+
 type ChannelInfo struct {
 	Name    string
 	ID      string
@@ -77,6 +166,7 @@ type UserInfo struct {
 	DisplayName string
 	Ghost       bool
 	Me          bool
+	Bot         bool // bot accounts, webhooks and integrations
 	Username    string
 	TeamID      string
 	FirstName   string
@@ -91,6 +181,8 @@ type Credentials struct {
 	Server   string
 	Token    string
 	MFAToken string
+	// SessionToken of an earlier session, used instead of Pass and MFAToken.
+	SessionToken string
 }
 
 type Event struct {
@@ -179,8 +271,29 @@ type StatusChangeEvent struct {
 	Status string
 }
 
+// ThreadFollowEvent is sent when we start or stop following a thread, eg by replying to it.
+type ThreadFollowEvent struct {
+	RootID    string
+	Following bool
+}
+
+// ChannelNotifyEvent is sent when our notification preferences of a channel change.
+type ChannelNotifyEvent struct {
+	ChannelID string
+	Notify    *ChannelNotify
+}
+
+// CategoriesEvent is sent when our categories change.
+type CategoriesEvent struct{}
+
 type LogoutEvent struct{}
 
+// DisconnectEvent is sent when the bridge lost its connection to the server.
+type DisconnectEvent struct{}
+
+// ReconnectEvent is sent when the connection of the bridge is back.
+type ReconnectEvent struct{}
+
 type File struct {
 	Name string
 }
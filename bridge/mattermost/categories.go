@@ -0,0 +1,103 @@
+package mattermost
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/42wim/matterircd/bridge"
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+// GetCategories returns our sidebar categories of all teams, in the order of the sidebar.
+func (m *Mattermost) GetCategories() ([]*bridge.CategoryInfo, error) {
+	var categories []*bridge.CategoryInfo
+
+	for _, teamID := range m.teamIDs() {
+		ordered, _, err := m.mc.Client.GetSidebarCategoriesForTeamForUser(m.mc.User.Id, teamID, "")
+		if err != nil {
+			return nil, err
+		}
+
+		byID := make(map[string]*model.SidebarCategoryWithChannels, len(ordered.Categories))
+		for _, c := range ordered.Categories {
+			byID[c.Id] = c
+		}
+
+		for _, id := range ordered.Order {
+			c, ok := byID[id]
+			if !ok {
+				continue
+			}
+
+			categories = append(categories, &bridge.CategoryInfo{
+				ID:         c.Id,
+				TeamID:     c.TeamId,
+				Name:       c.DisplayName,
+				ChannelIDs: c.Channels,
+			})
+		}
+	}
+
+	return categories, nil
+}
+
+// MoveChannel moves channelID to the top of our category named category, in the team of the
+// channel or in our own team for DMs.
+func (m *Mattermost) MoveChannel(channelID, category string) error {
+	teamID := m.mc.GetTeamFromChannel(channelID)
+	if teamID == "" {
+		teamID = m.mc.Team.ID
+	}
+
+	ordered, _, err := m.mc.Client.GetSidebarCategoriesForTeamForUser(m.mc.User.Id, teamID, "")
+	if err != nil {
+		return err
+	}
+
+	var from, to *model.SidebarCategoryWithChannels
+
+	for _, c := range ordered.Categories {
+		if strings.EqualFold(c.DisplayName, category) {
+			to = c
+		}
+
+		for _, id := range c.Channels {
+			if id == channelID {
+				from = c
+			}
+		}
+	}
+
+	switch {
+	case to == nil:
+		return errors.New("no category " + category)
+	case from == to:
+		return nil
+	}
+
+	to.Channels = append([]string{channelID}, to.Channels...)
+	updated := []*model.SidebarCategoryWithChannels{to}
+
+	if from != nil {
+		channels := make([]string, 0, len(from.Channels))
+		for _, id := range from.Channels {
+			if id != channelID {
+				channels = append(channels, id)
+			}
+		}
+
+		from.Channels = channels
+		updated = append(updated, from)
+	}
+
+	_, _, err = m.mc.Client.UpdateSidebarCategoriesForTeamForUser(m.mc.User.Id, teamID, updated)
+
+	return err
+}
+
+func (m *Mattermost) handleCategoriesEvent() {
+	m.eventChan <- &bridge.Event{
+		Type: "categories",
+		Data: &bridge.CategoriesEvent{},
+	}
+}
@@ -7,6 +7,8 @@ import (
 	"math/rand"
 	"regexp"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/42wim/matterircd/bridge"
@@ -26,6 +28,7 @@ type Mattermost struct {
 	quitChan    []chan struct{}
 	eventChan   chan *bridge.Event
 	v           *viper.Viper
+	vMutex      sync.RWMutex
 	connected   bool
 	instanceTag string
 
@@ -60,7 +63,18 @@ func New(v *viper.Viper, cred bridge.Credentials, eventChan chan *bridge.Event,
 
 	fmt.Println("loggerlevel:", ourlog.GetLevel())
 
-	mc, err := m.loginToMattermost(onWsConnect)
+	// matterclient reconnects by itself and calls OnWsConnect on every connect, after the
+	// first one we resync what we missed.
+	var connected int32
+
+	mc, err := m.loginToMattermost(func() {
+		if atomic.CompareAndSwapInt32(&connected, 0, 1) {
+			onWsConnect()
+			return
+		}
+
+		m.eventChan <- &bridge.Event{Type: "reconnect", Data: &bridge.ReconnectEvent{}}
+	})
 	if err != nil {
 		return nil, nil, err
 	}
@@ -91,26 +105,31 @@ func New(v *viper.Viper, cred bridge.Credentials, eventChan chan *bridge.Event,
 func (m *Mattermost) loginToMattermost(onWsConnect func()) (*matterclient.Client, error) {
 	matterclient.Matterircd = true
 
-	mc := matterclient.New(m.credentials.Login, m.credentials.Pass, m.credentials.Team, m.credentials.Server, m.credentials.MFAToken)
-	if m.v.GetBool("mattermost.Insecure") {
+	pass, mfaToken := m.credentials.Pass, m.credentials.MFAToken
+	if m.credentials.SessionToken != "" {
+		pass, mfaToken = model.SessionCookieToken+"="+m.credentials.SessionToken, ""
+	}
+
+	mc := matterclient.New(m.credentials.Login, pass, m.credentials.Team, m.credentials.Server, mfaToken)
+	if m.config().GetBool("mattermost.Insecure") {
 		mc.Credentials.NoTLS = true
 	}
 
-	mc.AntiIdle = !m.v.GetBool("mattermost.DisableAutoView") || m.v.GetBool("mattermost.ForceAntiIdle")
-	mc.AntiIdleChan = m.v.GetString("mattermost.AntiIdleChannel")
-	mc.AntiIdleIntvl = m.v.GetInt("mattermost.AntiIdleInterval")
+	mc.AntiIdle = !m.config().GetBool("mattermost.DisableAutoView") || m.config().GetBool("mattermost.ForceAntiIdle")
+	mc.AntiIdleChan = m.config().GetString("mattermost.AntiIdleChannel")
+	mc.AntiIdleIntvl = m.config().GetInt("mattermost.AntiIdleInterval")
 	mc.OnWsConnect = onWsConnect
 
-	mc.Timeout = m.v.GetInt("ClientTimeout")
+	mc.Timeout = m.config().GetInt("ClientTimeout")
 	if mc.Timeout == 0 {
 		mc.Timeout = 10
 	}
 
-	if m.v.GetBool("debug") {
+	if m.config().GetBool("debug") {
 		mc.SetLogLevel("debug")
 	}
 
-	mc.Credentials.SkipTLSVerify = m.v.GetBool("mattermost.SkipTLSVerify")
+	mc.Credentials.SkipTLSVerify = m.config().GetBool("mattermost.SkipTLSVerify")
 
 	logger.Infof("login as %s (team: %s) on %s", m.credentials.Login, m.credentials.Team, m.credentials.Server)
 
@@ -129,9 +148,25 @@ func (m *Mattermost) loginToMattermost(onWsConnect func()) (*matterclient.Client
 
 	go m.handleWsMessage(quitChan)
 
+	supervisorQuit := make(chan struct{})
+	m.quitChan = append(m.quitChan, supervisorQuit)
+
+	// no Reconnect, matterclient reconnects by itself.
+	supervisor := &bridge.Supervisor{
+		Connected: m.wsConnected,
+		EventChan: m.eventChan,
+		Interval:  10 * time.Second,
+	}
+
+	go supervisor.Run(supervisorQuit)
+
 	return mc, nil
 }
 
+func (m *Mattermost) wsConnected() bool {
+	return m.mc.WsConnected && m.mc.WsClient != nil && m.mc.WsClient.ListenError == nil
+}
+
 //nolint:cyclop
 func (m *Mattermost) handleWsMessage(quitChan chan struct{}) {
 	updateChannelsThrottle := time.NewTicker(time.Second * 60)
@@ -182,6 +217,13 @@ func (m *Mattermost) handleWsMessage(quitChan chan struct{}) {
 				m.handleStatusChangeEvent(message.Raw)
 			case model.WebsocketEventReactionAdded, model.WebsocketEventReactionRemoved:
 				m.handleReactionEvent(message.Raw)
+			case model.WebsocketEventThreadFollowChanged:
+				m.handleThreadFollowEvent(message.Raw)
+			case model.WebsocketEventChannelMemberUpdated:
+				m.handleChannelMemberUpdatedEvent(message.Raw)
+			case model.WebsocketEventSidebarCategoryCreated, model.WebsocketEventSidebarCategoryUpdated,
+				model.WebsocketEventSidebarCategoryDeleted, model.WebsocketEventSidebarCategoryOrderUpdated:
+				m.handleCategoriesEvent()
 			}
 		}
 	}
@@ -210,20 +252,13 @@ func (m *Mattermost) Invite(channelID, username string) error {
 }
 
 func (m *Mattermost) Join(channelName string) (string, string, error) {
-	teamID := ""
-
-	sp := strings.Split(channelName, "/")
-	if len(sp) > 1 {
-		team, _, _ := m.mc.Client.GetTeamByName(sp[0], "")
-		if team == nil {
+	teamID, channelName := m.splitTeamChannel(channelName)
+	if teamID == "" {
+		// not one of our teams
+		if strings.Contains(channelName, "/") {
 			return "", "", fmt.Errorf("cannot join channel (+i)")
 		}
 
-		teamID = team.Id
-		channelName = sp[1]
-	}
-
-	if teamID == "" {
 		teamID = m.mc.Team.ID
 	}
 
@@ -249,13 +284,7 @@ func (m *Mattermost) List() (map[string]string, error) {
 			continue
 		}
 
-		channelName := "#" + channel.Name
-		// prefix channels outside of our team with team name
-		if channel.TeamId != m.mc.Team.ID {
-			channelName = m.mc.GetTeamName(channel.TeamId) + "/" + channel.Name
-		}
-
-		channelinfo[channelName] = strings.ReplaceAll(channel.Header, "\n", " | ")
+		channelinfo[m.ircChannelName(channel.Name, channel.TeamId)] = strings.ReplaceAll(channel.Header, "\n", " | ")
 	}
 
 	return channelinfo, nil
@@ -296,6 +325,46 @@ func (m *Mattermost) Logout() error {
 	return nil
 }
 
+// UpdateConfig replaces the config we read settings from, it's done while logged in.
+func (m *Mattermost) UpdateConfig(v *viper.Viper) {
+	m.vMutex.Lock()
+	defer m.vMutex.Unlock()
+
+	m.v = v
+}
+
+func (m *Mattermost) config() *viper.Viper {
+	m.vMutex.RLock()
+	defer m.vMutex.RUnlock()
+
+	return m.v
+}
+
+func (m *Mattermost) SessionToken() string {
+	return m.mc.Client.AuthToken
+}
+
+// KeepSession keeps the session on the server when logging out, its token is stored
+// and reused on the next login (see RevokeSession).
+func (m *Mattermost) KeepSession() {
+	m.keepServerSession()
+}
+
+func (m *Mattermost) RevokeSession() error {
+	_, err := m.mc.Client.Logout()
+
+	// it's gone already, nothing to end when logging out.
+	m.keepServerSession()
+
+	return err
+}
+
+// keepServerSession makes matterclient skip ending the session on logout, it does that
+// for logins with a session token.
+func (m *Mattermost) keepServerSession() {
+	m.mc.Credentials.Pass = model.SessionCookieToken + "=" + m.mc.Client.AuthToken
+}
+
 func (m *Mattermost) MsgUser(userID, text string) (string, error) {
 	return m.MsgUserThread(userID, "", text)
 }
@@ -489,19 +558,8 @@ func (m *Mattermost) GetChannelName(channelID string) string {
 		return channelName
 	}
 
-	teamID := m.mc.GetTeamFromChannel(channelID)
-	teamName := m.mc.GetTeamName(teamID)
-
 	if channelName != "" {
-		if (teamName != "" && teamID != m.mc.Team.ID) || m.v.GetBool("mattermost.PrefixMainTeam") {
-			name = "#" + teamName + "/" + channelName
-		}
-		if teamID == m.mc.Team.ID && !m.v.GetBool("mattermost.PrefixMainTeam") {
-			name = "#" + channelName
-		}
-		if teamID == "G" {
-			name = "#" + channelName
-		}
+		name = m.ircChannelName(channelName, m.mc.GetTeamFromChannel(channelID))
 	} else {
 		name = channelID
 	}
@@ -509,6 +567,40 @@ func (m *Mattermost) GetChannelName(channelID string) string {
 	return name
 }
 
+// ircChannelName returns the IRC name of channelName in teamID, channels outside
+// of our team (or all with PrefixMainTeam) get the team name as prefix.
+func (m *Mattermost) ircChannelName(channelName, teamID string) string {
+	teamName := m.mc.GetTeamName(teamID)
+
+	if teamName != "" && teamID != "G" && (teamID != m.mc.Team.ID || m.config().GetBool("mattermost.PrefixMainTeam")) {
+		return "#" + teamName + "/" + channelName
+	}
+
+	return "#" + channelName
+}
+
+// splitTeamChannel splits a channel name in the form team/channel.
+// Returns an empty team ID if name isn't prefixed with one of our teams.
+func (m *Mattermost) splitTeamChannel(name string) (string, string) {
+	name = strings.TrimPrefix(name, "#")
+
+	teamName, channelName, ok := strings.Cut(name, "/")
+	if !ok {
+		return "", name
+	}
+
+	m.mc.RLock()
+	defer m.mc.RUnlock()
+
+	for _, t := range m.mc.OtherTeams {
+		if t.Team != nil && strings.EqualFold(t.Team.Name, teamName) {
+			return t.ID, channelName
+		}
+	}
+
+	return "", name
+}
+
 func (m *Mattermost) GetChannelUsers(channelID string) ([]*bridge.UserInfo, error) {
 	var (
 		mmusers, mmusersPaged []*model.User
@@ -653,7 +745,7 @@ func (m *Mattermost) createUser(mmuser *model.User) *bridge.UserInfo {
 	}
 
 	nick := mmuser.Username
-	if m.v.GetBool("mattermost.PreferNickname") && isValidNick(mmuser.Nickname) {
+	if m.config().GetBool("mattermost.PreferNickname") && isValidNick(mmuser.Nickname) {
 		nick = mmuser.Nickname
 	}
 
@@ -674,6 +766,7 @@ func (m *Mattermost) createUser(mmuser *model.User) *bridge.UserInfo {
 		Roles:       mmuser.Roles,
 		Ghost:       true,
 		Me:          me,
+		Bot:         mmuser.IsBot,
 		TeamID:      teamID,
 		Username:    mmuser.Username,
 		FirstName:   mmuser.FirstName,
@@ -759,8 +852,8 @@ func (m *Mattermost) wsActionPostSkip(rmsg *model.WebSocketEvent) bool {
 
 	if data.RootId != "" {
 		msgID = data.RootId
-		if !m.v.GetBool("mattermost.hidereplies") {
-			newMsg, err := m.addParentMsg(data.RootId, data.Message, m.v.GetInt("mattermost.ShortenRepliesTo"), "@", m.v.GetBool("mattermost.unicode"))
+		if !m.config().GetBool("mattermost.hidereplies") {
+			newMsg, err := m.addParentMsg(data.RootId, data.Message, m.config().GetInt("mattermost.ShortenRepliesTo"), "@", m.config().GetBool("mattermost.unicode"))
 			if err == nil {
 				msg = newMsg
 			}
@@ -857,10 +950,10 @@ func (m *Mattermost) handleWsActionPost(rmsg *model.WebSocketEvent) {
 		return
 	}
 
-	if !m.v.GetBool("mattermost.hidereplies") && data.RootId != "" {
-		message, err := m.addParentMsg(data.RootId, data.Message, m.v.GetInt("mattermost.ShortenRepliesTo"), "@", m.v.GetBool("mattermost.unicode"))
+	if !m.config().GetBool("mattermost.hidereplies") && data.RootId != "" {
+		message, err := m.addParentMsg(data.RootId, data.Message, m.config().GetInt("mattermost.ShortenRepliesTo"), "@", m.config().GetBool("mattermost.unicode"))
 		if err != nil {
-			logger.Errorf("Unable to get parent post for %#v", data) //nolint:govet
+			logger.Errorf("Unable to get parent post for %#v", &data)
 		}
 		data.Message = message
 	}
@@ -893,8 +986,16 @@ func (m *Mattermost) handleWsActionPost(rmsg *model.WebSocketEvent) {
 		logger.Debugf("found override username %s", overrideUsername)
 		// only allow valid irc nicks
 		if validIRCNickRegExp.MatchString(overrideUsername) {
-			ghost.Nick = overrideUsername
-			ghost.Me = false
+			// a separate user for the webhook, the cached user of the webhook owner stays as is.
+			ghost = &bridge.UserInfo{
+				Nick:     overrideUsername,
+				User:     data.UserId + "/" + overrideUsername,
+				Real:     overrideUsername,
+				Host:     ghost.Host,
+				Ghost:    true,
+				Bot:      true,
+				Username: ghost.Username,
+			}
 		}
 	}
 
@@ -1008,7 +1109,7 @@ func (m *Mattermost) handleWsActionPost(rmsg *model.WebSocketEvent) {
 			strings.Contains(data.Message, "@all"):
 
 			messageType := "notice"
-			if m.v.GetBool("mattermost.disabledefaultmentions") {
+			if m.config().GetBool("mattermost.disabledefaultmentions") {
 				messageType = ""
 			}
 			event := &bridge.Event{
@@ -1065,7 +1166,7 @@ func (m *Mattermost) handleWsActionPost(rmsg *model.WebSocketEvent) {
 	}
 
 	logger.Debugf("handleWsActionPost() user %s sent %#v", m.mc.GetUser(data.UserId).Username, data.Message)
-	logger.Debugf("%#v", data) //nolint:govet
+	logger.Debugf("%#v", &data)
 }
 
 func (m *Mattermost) getFilesFromData(data *model.Post) []*bridge.File {
@@ -1319,8 +1420,8 @@ func (m *Mattermost) handleReactionEvent(rmsg *model.WebSocketEvent) {
 
 	var parentUser *bridge.UserInfo
 	rMessage := ""
-	if !m.v.GetBool("mattermost.hidereplies") {
-		message, err := m.addParentMsg(reaction.PostId, "", m.v.GetInt("mattermost.ShortenRepliesTo"), "@", m.v.GetBool("mattermost.unicode"))
+	if !m.config().GetBool("mattermost.hidereplies") {
+		message, err := m.addParentMsg(reaction.PostId, "", m.config().GetInt("mattermost.ShortenRepliesTo"), "@", m.config().GetBool("mattermost.unicode"))
 		if err != nil {
 			logger.Errorf("Unable to get parent post for %#v", reaction)
 		}
@@ -1371,6 +1472,19 @@ func (m *Mattermost) GetTeamName(teamID string) string {
 	return m.mc.GetTeamName(teamID)
 }
 
+// teamIDs returns the IDs of all our teams.
+func (m *Mattermost) teamIDs() []string {
+	m.mc.RLock()
+	defer m.mc.RUnlock()
+
+	teamIDs := make([]string, 0, len(m.mc.OtherTeams))
+	for _, t := range m.mc.OtherTeams {
+		teamIDs = append(teamIDs, t.ID)
+	}
+
+	return teamIDs
+}
+
 func (m *Mattermost) GetLastViewedAt(channelID string) int64 {
 	x := m.mc.GetLastViewedAt(channelID)
 	logger.Tracef("getLastViewedAt %s: %#v", channelID, x)
@@ -1403,8 +1517,38 @@ func (m *Mattermost) UpdateLastViewedUser(userID string) error {
 	}
 }
 
+// SearchPosts searches in all our teams.
 func (m *Mattermost) SearchPosts(search string) interface{} {
-	return m.mc.SearchPosts(search)
+	m.mc.RLock()
+	teamIDs := make([]string, 0, len(m.mc.OtherTeams))
+	for _, t := range m.mc.OtherTeams {
+		teamIDs = append(teamIDs, t.ID)
+	}
+	m.mc.RUnlock()
+
+	list := model.NewPostList()
+
+	for _, teamID := range teamIDs {
+		res, _, err := m.mc.Client.SearchPosts(teamID, search, false)
+		if err != nil || res == nil {
+			logger.Errorf("search in team %s failed: %s", teamID, err)
+			continue
+		}
+
+		for _, id := range res.Order {
+			// DMs and group messages are found in every team.
+			if _, ok := list.Posts[id]; ok {
+				continue
+			}
+
+			list.AddPost(res.Posts[id])
+			list.AddOrder(id)
+		}
+	}
+
+	list.SortByCreateAt()
+
+	return list
 }
 
 func (m *Mattermost) GetFileLinks(fileIDs []string) []string {
@@ -1434,8 +1578,14 @@ func (m *Mattermost) GetPostThread(postID string) interface{} {
 	return m.mc.GetPostThread(postID)
 }
 
+// GetChannelID returns the ID of channel name, a name in the form team/channel
+// is looked up in that team, other names in teamID.
 func (m *Mattermost) GetChannelID(name, teamID string) string {
-	return m.mc.GetChannelID(name, teamID)
+	if id, channelName := m.splitTeamChannel(name); id != "" {
+		return m.mc.GetChannelID(channelName, id)
+	}
+
+	return m.mc.GetChannelID(strings.TrimPrefix(name, "#"), teamID)
 }
 
 func (m *Mattermost) Connected() bool {
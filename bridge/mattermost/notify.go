@@ -0,0 +1,76 @@
+package mattermost
+
+import (
+	"encoding/json"
+
+	"github.com/42wim/matterircd/bridge"
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+// channelNotify returns the notification preferences of member.
+func channelNotify(member *model.ChannelMember) *bridge.ChannelNotify {
+	desktop := member.NotifyProps[model.DesktopNotifyProp]
+	if desktop == model.ChannelNotifyDefault {
+		desktop = ""
+	}
+
+	return &bridge.ChannelNotify{
+		Muted:                 member.IsChannelMuted(),
+		Desktop:               desktop,
+		IgnoreChannelMentions: member.NotifyProps[model.IgnoreChannelMentionsNotifyProp] == model.IgnoreChannelMentionsOn,
+	}
+}
+
+// GetChannelNotify returns the notification preferences of our channels in all our teams.
+func (m *Mattermost) GetChannelNotify() (map[string]*bridge.ChannelNotify, error) {
+	notify := make(map[string]*bridge.ChannelNotify)
+
+	for _, teamID := range m.teamIDs() {
+		members, _, err := m.mc.Client.GetChannelMembersForUser(m.mc.User.Id, teamID, "")
+		if err != nil {
+			return nil, err
+		}
+
+		for i := range members {
+			notify[members[i].ChannelId] = channelNotify(&members[i])
+		}
+	}
+
+	return notify, nil
+}
+
+// MuteChannel mutes or unmutes channelID, like the mattermost clients do.
+func (m *Mattermost) MuteChannel(channelID string, mute bool) error {
+	markUnread := model.ChannelMarkUnreadAll
+	if mute {
+		markUnread = model.ChannelMarkUnreadMention
+	}
+
+	_, err := m.mc.Client.UpdateChannelNotifyProps(channelID, m.mc.User.Id, map[string]string{
+		model.MarkUnreadNotifyProp: markUnread,
+	})
+
+	return err
+}
+
+func (m *Mattermost) handleChannelMemberUpdatedEvent(rmsg *model.WebSocketEvent) {
+	data, _ := rmsg.GetData()["channelMember"].(string)
+
+	var member model.ChannelMember
+	if err := json.Unmarshal([]byte(data), &member); err != nil {
+		logger.Errorf("channel member update: %s", err)
+		return
+	}
+
+	if member.UserId != m.mc.User.Id {
+		return
+	}
+
+	m.eventChan <- &bridge.Event{
+		Type: "channel_notify",
+		Data: &bridge.ChannelNotifyEvent{
+			ChannelID: member.ChannelId,
+			Notify:    channelNotify(&member),
+		},
+	}
+}
@@ -0,0 +1,119 @@
+package mattermost
+
+import (
+	"sort"
+
+	"github.com/42wim/matterircd/bridge"
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+// threadsPageSize is the number of threads fetched per request.
+const threadsPageSize = 50
+
+// GetThreads returns the threads we follow in all our teams, most recently active first.
+func (m *Mattermost) GetThreads(unreadOnly bool) ([]*bridge.ThreadInfo, error) {
+	var threads []*bridge.ThreadInfo
+
+	seen := make(map[string]bool)
+
+	for _, teamID := range m.teamIDs() {
+		opts := model.GetUserThreadsOpts{PageSize: threadsPageSize, Unread: unreadOnly, ThreadsOnly: true}
+
+		for {
+			res, _, err := m.mc.Client.GetUserThreads(m.mc.User.Id, teamID, opts)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, t := range res.Threads {
+				// threads of DMs and group messages are in every team.
+				if seen[t.PostId] || t.Post == nil {
+					continue
+				}
+
+				seen[t.PostId] = true
+
+				threads = append(threads, &bridge.ThreadInfo{
+					RootID:         t.PostId,
+					ChannelID:      t.Post.ChannelId,
+					UserID:         t.Post.UserId,
+					Message:        t.Post.Message,
+					ReplyCount:     t.ReplyCount,
+					LastReplyAt:    t.LastReplyAt,
+					UnreadReplies:  t.UnreadReplies,
+					UnreadMentions: t.UnreadMentions,
+				})
+			}
+
+			if len(res.Threads) < threadsPageSize {
+				break
+			}
+
+			opts.Before = res.Threads[len(res.Threads)-1].PostId
+		}
+	}
+
+	sort.Slice(threads, func(i, j int) bool { return threads[i].LastReplyAt > threads[j].LastReplyAt })
+
+	return threads, nil
+}
+
+// FollowThread follows or unfollows the thread of rootID (or of a reply in it).
+func (m *Mattermost) FollowThread(rootID string, follow bool) error {
+	rootID, teamID, err := m.threadOf(rootID)
+	if err != nil {
+		return err
+	}
+
+	_, err = m.mc.Client.UpdateThreadFollowForUser(m.mc.User.Id, teamID, rootID, follow)
+
+	return err
+}
+
+// UpdateThreadRead marks the thread of rootID (or of a reply in it) as read.
+func (m *Mattermost) UpdateThreadRead(rootID string) error {
+	rootID, teamID, err := m.threadOf(rootID)
+	if err != nil {
+		return err
+	}
+
+	_, _, err = m.mc.Client.UpdateThreadReadForUser(m.mc.User.Id, teamID, rootID, model.GetMillis())
+
+	return err
+}
+
+// threadOf returns the root of the thread of postID and its team, our own team for DMs.
+func (m *Mattermost) threadOf(postID string) (string, string, error) {
+	post, _, err := m.mc.Client.GetPost(postID, "")
+	if err != nil {
+		return "", "", err
+	}
+
+	rootID := post.RootId
+	if rootID == "" {
+		rootID = post.Id
+	}
+
+	if teamID := m.mc.GetTeamFromChannel(post.ChannelId); teamID != "" {
+		return rootID, teamID, nil
+	}
+
+	return rootID, m.mc.Team.ID, nil
+}
+
+func (m *Mattermost) handleThreadFollowEvent(rmsg *model.WebSocketEvent) {
+	rootID, _ := rmsg.GetData()["thread_id"].(string)
+	state, _ := rmsg.GetData()["state"].(bool)
+
+	if rootID == "" {
+		return
+	}
+
+	m.eventChan <- &bridge.Event{
+		Type: "thread_follow",
+		Data: &bridge.ThreadFollowEvent{
+			RootID:    rootID,
+			Following: state,
+		},
+	}
+}
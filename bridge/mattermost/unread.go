@@ -0,0 +1,84 @@
+package mattermost
+
+import (
+	"github.com/42wim/matterircd/bridge"
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+// GetUnread returns the channels of all our teams with unread messages or mentions.
+// Messages of channels only marked unread on mentions aren't counted.
+func (m *Mattermost) GetUnread() ([]*bridge.UnreadInfo, error) {
+	var unread []*bridge.UnreadInfo
+
+	seen := make(map[string]bool)
+
+	for _, teamID := range m.teamIDs() {
+		// the cached channels don't have the current message counts.
+		channels, _, err := m.mc.Client.GetChannelsForTeamForUser(teamID, m.mc.User.Id, false, "")
+		if err != nil {
+			return nil, err
+		}
+
+		members, _, err := m.mc.Client.GetChannelMembersForUser(m.mc.User.Id, teamID, "")
+		if err != nil {
+			return nil, err
+		}
+
+		byID := make(map[string]model.ChannelMember, len(members))
+		for _, member := range members {
+			byID[member.ChannelId] = member
+		}
+
+		for _, channel := range channels {
+			member, ok := byID[channel.Id]
+			// DMs and group messages are in every team.
+			if !ok || seen[channel.Id] {
+				continue
+			}
+
+			seen[channel.Id] = true
+
+			info := &bridge.UnreadInfo{
+				ChannelID: channel.Id,
+				Messages:  channel.TotalMsgCount - member.MsgCount,
+				Mentions:  member.MentionCount,
+			}
+
+			if member.NotifyProps[model.MarkUnreadNotifyProp] == model.ChannelMarkUnreadMention || info.Messages < 0 {
+				info.Messages = 0
+			}
+
+			if info.Messages > 0 || info.Mentions > 0 {
+				unread = append(unread, info)
+			}
+		}
+	}
+
+	return unread, nil
+}
+
+// MarkAllRead marks the unread channels and the threads of all our teams as read.
+func (m *Mattermost) MarkAllRead() ([]string, error) {
+	unread, err := m.GetUnread()
+	if err != nil {
+		return nil, err
+	}
+
+	marked := make([]string, 0, len(unread))
+
+	for _, info := range unread {
+		if err := m.mc.UpdateLastViewed(info.ChannelID); err != nil {
+			return marked, err
+		}
+
+		marked = append(marked, info.ChannelID)
+	}
+
+	for _, teamID := range m.teamIDs() {
+		if _, err := m.mc.Client.UpdateThreadsReadForUser(m.mc.User.Id, teamID); err != nil {
+			return marked, err
+		}
+	}
+
+	return marked, nil
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/42wim/matterircd/bridge"
@@ -21,13 +22,16 @@ type Slack struct {
 	sinfo        *slack.Info
 	susers       map[string]slack.User
 	connected    bool
+	rtmConnected int32
+	quit         chan struct{}
 	userlistdone bool
 	credentials  bridge.Credentials
 	eventChan    chan *bridge.Event
 	onConnect    func()
 	msgLast      map[string]string
 	sync.RWMutex
-	v *viper.Viper
+	v      *viper.Viper
+	vMutex sync.RWMutex
 }
 
 var logger *logrus.Entry
@@ -138,6 +142,8 @@ func (s *Slack) Logout() error {
 
 	s.sc = nil
 
+	close(s.quit)
+
 	logger.Info("logout succeeded")
 
 	s.eventChan <- &bridge.Event{
@@ -150,6 +156,24 @@ func (s *Slack) Logout() error {
 	return nil
 }
 
+func (s *Slack) SessionToken() string {
+	return s.credentials.Token
+}
+
+// KeepSession does nothing, logging out of slack doesn't end the session.
+func (s *Slack) KeepSession() {}
+
+func (s *Slack) RevokeSession() error {
+	token, _, err := passwordToTokenAndCookie(s.credentials.Token)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.sc.SendAuthRevoke(token)
+
+	return err
+}
+
 func (s *Slack) createSlackMsgOption(text string) []slack.MsgOption {
 	np := slack.NewPostMessageParameters()
 	np.AsUser = true
@@ -228,6 +252,21 @@ func (s *Slack) StatusUsers() (map[string]string, error) {
 	return make(map[string]string), nil
 }
 
+// UpdateConfig replaces the config we read settings from, it's done while logged in.
+func (s *Slack) UpdateConfig(v *viper.Viper) {
+	s.vMutex.Lock()
+	defer s.vMutex.Unlock()
+
+	s.v = v
+}
+
+func (s *Slack) config() *viper.Viper {
+	s.vMutex.RLock()
+	defer s.vMutex.RUnlock()
+
+	return s.v
+}
+
 func (s *Slack) Protocol() string {
 	return "slack"
 }
@@ -436,9 +475,9 @@ func (s *Slack) GetChannelID(name, teamID string) string {
 func (s *Slack) allowedLogin() error {
 	// we only know which server we are connecting to when we actually are connected.
 	// disconnect if we're not allowed
-	if len(s.v.GetStringSlice("slack.restrict")) > 0 {
+	if len(s.config().GetStringSlice("slack.restrict")) > 0 {
 		ok := false
-		for _, domain := range s.v.GetStringSlice("slack.restrict") {
+		for _, domain := range s.config().GetStringSlice("slack.restrict") {
 			if domain == s.sinfo.Team.Domain {
 				ok = true
 				break
@@ -451,9 +490,9 @@ func (s *Slack) allowedLogin() error {
 	}
 	// we only know which user we are when we actually are connected.
 	// disconnect if we're not allowed
-	if len(s.v.GetStringSlice("slack.DenyUsers")) > 0 {
+	if len(s.config().GetStringSlice("slack.DenyUsers")) > 0 {
 		ok := false
-		for _, user := range s.v.GetStringSlice("slack.DenyUsers") {
+		for _, user := range s.config().GetStringSlice("slack.DenyUsers") {
 			if user == s.sinfo.User.Name {
 				ok = true
 				break
@@ -471,6 +510,10 @@ func (s *Slack) allowedLogin() error {
 func (s *Slack) loginToSlack() (*slack.Client, error) {
 	var err error
 
+	if s.credentials.SessionToken != "" {
+		s.credentials.Token = s.credentials.SessionToken
+	}
+
 	if s.credentials.Token == "" {
 		s.credentials.Token, err = s.getSlackToken()
 		if err != nil {
@@ -518,8 +561,18 @@ func (s *Slack) loginToSlack() (*slack.Client, error) {
 		return nil, err
 	}
 
+	atomic.StoreInt32(&s.rtmConnected, 1)
+	s.quit = make(chan struct{})
+
+	supervisor := &bridge.Supervisor{
+		Connected: func() bool { return atomic.LoadInt32(&s.rtmConnected) == 1 },
+		EventChan: s.eventChan,
+		Interval:  10 * time.Second,
+	}
+
 	go s.handleSlack()
 	go s.onConnect()
+	go supervisor.Run(s.quit)
 
 	s.connected = true
 
@@ -540,8 +593,17 @@ func (s *Slack) handleSlack() {
 			s.handleMemberLeftChannel(ev)
 		case *slack.MemberJoinedChannelEvent:
 			s.handleMemberJoinedChannel(ev)
+		case *slack.ConnectedEvent:
+			// the RTM reconnected by itself, the user needs to catch up.
+			if atomic.CompareAndSwapInt32(&s.rtmConnected, 0, 1) {
+				s.eventChan <- &bridge.Event{Type: "reconnect", Data: &bridge.ReconnectEvent{}}
+			}
 		case *slack.DisconnectedEvent:
-			logger.Debug("disconnected event received, we should reconnect now..")
+			// the RTM reconnects by itself, the supervisor tells the user it's gone.
+			logger.Debug("disconnected event received, intentional: ", ev.Intentional)
+			if !ev.Intentional {
+				atomic.StoreInt32(&s.rtmConnected, 0)
+			}
 		case *slack.ReactionAddedEvent:
 			logger.Debugf("ReactionAdded msg %#v", ev)
 			ts := formatTS(ev.Item.Timestamp)
@@ -686,7 +748,13 @@ func (s *Slack) getSlackUserFromMessage(rmsg *slack.MessageEvent) (*slack.User,
 				LastName:  "bot",
 				RealName:  "bot",
 			},
-			Name: rmsg.Username,
+			Name:  rmsg.Username,
+			IsBot: true,
+		}
+
+		// integrations can post with different usernames, give each their own user.
+		if rmsg.Username != "" {
+			suser.ID = rmsg.BotID + "/" + rmsg.Username
 		}
 
 		if rmsg.Username == "" {
@@ -877,7 +945,7 @@ func (s *Slack) createUser(slackuser *slack.User) *bridge.UserInfo {
 	}
 
 	nick := slackuser.Name
-	if (s.v.GetBool("slack.PreferNickname") || s.v.GetBool("slack.UseDisplayName")) && isValidNick(slackuser.Profile.DisplayName) {
+	if (s.config().GetBool("slack.PreferNickname") || s.config().GetBool("slack.UseDisplayName")) && isValidNick(slackuser.Profile.DisplayName) {
 		nick = slackuser.Profile.DisplayName
 	}
 
@@ -896,6 +964,7 @@ func (s *Slack) createUser(slackuser *slack.User) *bridge.UserInfo {
 		DisplayName: slackuser.Profile.DisplayName,
 		Ghost:       true,
 		Me:          me,
+		Bot:         slackuser.IsBot,
 		Username:    slackuser.Profile.RealName,
 		FirstName:   slackuser.Profile.FirstName,
 		LastName:    slackuser.Profile.LastName,
@@ -0,0 +1,80 @@
+package bridge
+
+import (
+	"time"
+
+	"github.com/jpillora/backoff"
+)
+
+// Supervisor watches the connection of a bridge. When the connection is lost it sends a
+// DisconnectEvent and asks the bridge to reconnect, with a backoff, until the connection
+// is back. Then it sends a ReconnectEvent so the user can catch up with what was missed.
+// Bridges that reconnect by themselves send the ReconnectEvent themselves, also for the
+// reconnects that happen between two checks.
+type Supervisor struct {
+	// Connected returns whether the bridge is connected.
+	Connected func() bool
+	// Reconnect starts a reconnect, nil when the bridge reconnects (and sends the
+	// ReconnectEvent) by itself.
+	Reconnect func()
+	// EventChan gets the events.
+	EventChan chan *Event
+	// Interval between the checks of the connection.
+	Interval time.Duration
+}
+
+// Run watches the connection until quit receives.
+func (s *Supervisor) Run(quit chan struct{}) {
+	b := &backoff.Backoff{
+		Min:    time.Second,
+		Max:    5 * time.Minute,
+		Jitter: true,
+	}
+
+	for {
+		select {
+		case <-quit:
+			return
+		case <-time.After(s.Interval):
+		}
+
+		if s.Connected() {
+			continue
+		}
+
+		if !s.send(quit, &Event{Type: "disconnect", Data: &DisconnectEvent{}}) {
+			return
+		}
+
+		for !s.Connected() {
+			if s.Reconnect != nil {
+				s.Reconnect()
+			}
+
+			select {
+			case <-quit:
+				return
+			case <-time.After(b.Duration()):
+			}
+		}
+
+		b.Reset()
+
+		if s.Reconnect == nil {
+			continue
+		}
+
+		if !s.send(quit, &Event{Type: "reconnect", Data: &ReconnectEvent{}}) {
+			return
+		}
+	}
+}
+
+func (s *Supervisor) send(quit chan struct{}, event *Event) bool {
+	select {
+	case <-quit:
+		return false
+	case s.EventChan <- event:
+		return true
+	}
+}
@@ -2,15 +2,26 @@ package config
 
 import (
 	"fmt"
+	"net"
+	"os"
+	"regexp"
 	"runtime"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/fsnotify/fsnotify"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
+	"golang.org/x/crypto/bcrypt"
 )
 
 var Logger *logrus.Entry
 
+var protocols = []string{"mattermost", "slack", "mastodon"}
+
+const watchDelay = time.Second
+
 func LoadConfig(cfgfile string) (*viper.Viper, error) {
 	v := viper.New()
 	v.SetConfigFile(cfgfile)
@@ -24,10 +35,126 @@ func LoadConfig(cfgfile string) (*viper.Viper, error) {
 		return nil, fmt.Errorf("error reading config file %s", err)
 	}
 
-	// reload config on file changes
-	if runtime.GOOS != "illumos" {
-		v.WatchConfig()
+	if err := Validate(v); err != nil {
+		return nil, fmt.Errorf("error in config file %s: %s", cfgfile, err)
 	}
 
 	return v, nil
 }
+
+// Watch calls onChange when cfgfile changes. Changes are collected for watchDelay,
+// editors often write a file in several steps.
+func Watch(cfgfile string, onChange func()) {
+	if runtime.GOOS == "illumos" {
+		return
+	}
+
+	var (
+		mu    sync.Mutex
+		timer *time.Timer
+	)
+
+	w := viper.New()
+	w.SetConfigFile(cfgfile)
+	w.OnConfigChange(func(fsnotify.Event) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if timer != nil {
+			timer.Stop()
+		}
+
+		timer = time.AfterFunc(watchDelay, onChange)
+	})
+	w.WatchConfig()
+}
+
+// Validate checks the settings of v which would break matterircd when wrong.
+func Validate(v *viper.Viper) error {
+	for _, key := range []string{"bind", "tlsbind"} {
+		if err := validateBind(v.GetString(key)); err != nil {
+			return fmt.Errorf("%s: %s", key, err)
+		}
+	}
+
+	if err := validateRegexpSettings(v, ""); err != nil {
+		return err
+	}
+
+	for nick := range v.GetStringMap("users") {
+		prefix := "users." + nick + "."
+
+		if err := validateHash(v.GetString(prefix + "password")); err != nil {
+			return fmt.Errorf("%spassword: %s", prefix, err)
+		}
+
+		switch protocol := strings.ToLower(v.GetString(prefix + "protocol")); protocol {
+		case "", "mattermost", "slack":
+		default:
+			return fmt.Errorf("%sprotocol: unknown protocol %s", prefix, protocol)
+		}
+
+		if err := validateRegexpSettings(v, prefix); err != nil {
+			return err
+		}
+	}
+
+	for name := range v.GetStringMap("opers") {
+		key := "opers." + name + ".password"
+		if err := validateHash(v.GetString(key)); err != nil {
+			return fmt.Errorf("%s: %s", key, err)
+		}
+	}
+
+	return nil
+}
+
+func validateBind(addr string) error {
+	if addr == "" || strings.ContainsRune(addr, os.PathSeparator) {
+		return nil
+	}
+
+	_, _, err := net.SplitHostPort(addr)
+
+	return err
+}
+
+// validateRegexpSettings checks the regexps of the join, highlight and virtual channel settings
+// of the protocols.
+func validateRegexpSettings(v *viper.Viper, prefix string) error {
+	for _, protocol := range protocols {
+		for _, setting := range []string{"joinexclude", "joininclude", "joinonly", "highlightkeywords"} {
+			key := prefix + protocol + "." + setting
+			for _, entry := range v.GetStringSlice(key) {
+				if _, err := regexp.Compile(entry); err != nil {
+					return fmt.Errorf("%s: %s", key, err)
+				}
+			}
+		}
+
+		key := prefix + protocol + ".virtualchannels"
+		for name, list := range v.GetStringMapStringSlice(key) {
+			if !strings.HasPrefix(name, "&") {
+				return fmt.Errorf("%s: %s: virtual channels are named &<name>", key, name)
+			}
+
+			for _, entry := range list {
+				if _, err := regexp.Compile(entry); err != nil {
+					return fmt.Errorf("%s: %s: %s", key, name, err)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func validateHash(hash string) error {
+	if hash == "" {
+		return nil
+	}
+
+	_, err := bcrypt.Cost([]byte(hash))
+
+	return err
+}
@@ -0,0 +1,65 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestValidate(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	assert.NoError(t, err)
+
+	for _, tc := range []struct {
+		name     string
+		settings map[string]interface{}
+		wantErr  string
+	}{
+		{"empty", nil, ""},
+		{"bind", map[string]interface{}{"bind": "127.0.0.1:6667", "tlsbind": ":6697"}, ""},
+		{"unix socket", map[string]interface{}{"bind": "/run/matterircd.sock"}, ""},
+		{"bind without port", map[string]interface{}{"bind": "127.0.0.1"}, "bind: "},
+		{"tlsbind without port", map[string]interface{}{"tlsbind": "localhost"}, "tlsbind: "},
+		{"regexps", map[string]interface{}{"mattermost.joinexclude": []string{"#town-square", "#.*marketing.*"}}, ""},
+		{"bad joinexclude", map[string]interface{}{"mattermost.joinexclude": []string{"#(unclosed"}}, "mattermost.joinexclude: "},
+		{"bad highlightkeywords", map[string]interface{}{"slack.highlightkeywords": []string{"*"}}, "slack.highlightkeywords: "},
+		{
+			"virtual channels", map[string]interface{}{"mattermost.virtualchannels": map[string][]string{"&alerts": {"#ops-.*"}}},
+			"",
+		},
+		{
+			"virtual channel name", map[string]interface{}{"mattermost.virtualchannels": map[string][]string{"alerts": {"#ops-.*"}}},
+			"virtual channels are named &<name>",
+		},
+		{
+			"virtual channel regexp", map[string]interface{}{"mattermost.virtualchannels": map[string][]string{"&alerts": {"#ops-(.*"}}},
+			"mattermost.virtualchannels: &alerts: ",
+		},
+		{"user", map[string]interface{}{"users.alice.password": string(hash), "users.alice.protocol": "Slack"}, ""},
+		{"user password", map[string]interface{}{"users.alice.password": "secret"}, "users.alice.password: "},
+		{"user protocol", map[string]interface{}{"users.alice.protocol": "irc"}, "users.alice.protocol: unknown protocol irc"},
+		{
+			"user regexps", map[string]interface{}{"users.alice.mattermost.joinonly": []string{"[a-"}},
+			"users.alice.mattermost.joinonly: ",
+		},
+		{"oper", map[string]interface{}{"opers.admin.password": string(hash)}, ""},
+		{"oper password", map[string]interface{}{"opers.admin.password": "$2a$10$short"}, "opers.admin.password: "},
+	} {
+		v := viper.New()
+		for key, value := range tc.settings {
+			v.Set(key, value)
+		}
+
+		err := Validate(v)
+		if tc.wantErr == "" {
+			assert.NoError(t, err, tc.name)
+			continue
+		}
+
+		if assert.Error(t, err, tc.name) {
+			assert.Contains(t, err.Error(), tc.wantErr, tc.name)
+		}
+	}
+}
@@ -2,12 +2,16 @@ package main
 
 import (
 	"crypto/tls"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"net"
 	"os"
+	"os/signal"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	bolt "go.etcd.io/bbolt"
@@ -28,8 +32,18 @@ var (
 	v       *viper.Viper
 
 	LastViewedSaveDB *bolt.DB
+
+	keypair     *keypairReloader
+	listeners   = make(map[string]net.Listener)
+	listenAddrs = make(map[string]string)
+	listenersMu sync.Mutex
+	reloadMu    sync.Mutex
+	vMu         sync.RWMutex
 )
 
+// shutdownTimeout is how long we wait for the sessions to end on shutdown.
+const shutdownTimeout = 10 * time.Second
+
 func main() {
 	ourlog := logrus.New()
 	ourlog.Formatter = &prefixed.TextFormatter{
@@ -98,15 +112,6 @@ func main() {
 		logger.Infof("WARNING: THIS IS A DEVELOPMENT VERSION. Things may break.")
 	}
 
-	if v.GetString("tlsbind") != "" {
-		go func() {
-			logger.Infof("Listening on %s (TLS)", v.GetString("tlsbind"))
-			socket := tlsbind()
-			defer socket.Close()
-			start(socket)
-		}()
-	}
-
 	mmLastViewedFile := "matterircd-lastsaved.db"
 	if statePath := v.GetString("mattermost.LastViewedSaveFile"); statePath != "" {
 		mmLastViewedFile = statePath
@@ -118,34 +123,159 @@ func main() {
 	defer db.Close()
 	LastViewedSaveDB = db
 
-	// backwards compatible
+	for _, name := range []string{"tlsbind", "bind"} {
+		if err := listen(name); err != nil {
+			logger.Error(err)
+			os.Exit(1)
+		}
+	}
 
-	if v.GetString("bind") != "" {
-		go func() {
-			var network string
-			if strings.ContainsRune(v.GetString("bind"), os.PathSeparator) {
-				network = "unix"
-			} else {
-				network = "tcp"
-			}
+	if _, err := os.Stat(*flagConfig); err == nil {
+		config.Watch(*flagConfig, func() {
+			logger.Infof("%s changed, reloading configuration", *flagConfig)
+			reloadConfig(*flagConfig)
+		})
+	}
 
-			socket, err := net.Listen(network, v.GetString("bind"))
-			if err != nil {
-				logger.Errorf("Can not listen on %s: %v", v.GetString("bind"), err)
-				os.Exit(1)
-			}
+	handleSignals(*flagConfig)
+}
 
-			logger.Infof("Listening on %s", v.GetString("bind"))
+// listen accepts connections on the address of setting name (bind or tlsbind).
+// A listener of a previous address is closed once the new one works.
+func listen(name string) error {
+	addr := v.GetString(name)
 
-			defer socket.Close()
-			start(socket)
-		}()
+	listenersMu.Lock()
+	defer listenersMu.Unlock()
+
+	old, ok := listeners[name]
+	if addr == listenAddrs[name] && (ok || addr == "") {
+		return nil
+	}
+
+	var (
+		socket net.Listener
+		err    error
+	)
+
+	switch {
+	case addr == "":
+	case name == "tlsbind":
+		socket, err = tlsbind(addr)
+	default:
+		socket, err = bind(addr)
+	}
+
+	if err != nil {
+		return fmt.Errorf("can not listen on %s: %w", addr, err)
+	}
+
+	if ok {
+		logger.Infof("Not listening on %s anymore", listenAddrs[name])
+		old.Close()
+		delete(listeners, name)
+	}
+
+	listenAddrs[name] = addr
+
+	if socket != nil {
+		listeners[name] = socket
+
+		go start(socket)
+	}
+
+	return nil
+}
+
+func bind(addr string) (net.Listener, error) {
+	network := "tcp"
+	if strings.ContainsRune(addr, os.PathSeparator) {
+		network = "unix"
+	}
+
+	socket, err := net.Listen(network, addr)
+	if err != nil {
+		return nil, err
 	}
 
-	select {}
+	logger.Infof("Listening on %s", addr)
+
+	return socket, nil
+}
+
+// handleSignals reloads the TLS certificates and the config on SIGHUP and shuts down
+// gracefully on SIGINT and SIGTERM.
+func handleSignals(cfgfile string) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM)
+
+	for sig := range sigs {
+		if sig == syscall.SIGHUP {
+			reload(cfgfile)
+			continue
+		}
+
+		logger.Infof("Received %s, shutting down", sig)
+
+		// stop accepting new connections.
+		listenersMu.Lock()
+		for name, socket := range listeners {
+			socket.Close()
+			delete(listeners, name)
+		}
+		listenersMu.Unlock()
+
+		irckit.Shutdown("matterircd is shutting down", shutdownTimeout)
+
+		return
+	}
 }
 
-func tlsbind() net.Listener {
+func reload(cfgfile string) {
+	if keypair != nil {
+		logger.Infof("Received SIGHUP, reloading TLS certificate and key from %q and %q", keypair.certPath, keypair.keyPath)
+		if err := keypair.maybeReload(); err != nil {
+			logger.Errorf("Keeping old TLS certificate because the new one could not be loaded: %v", err)
+		}
+	}
+
+	if _, err := os.Stat(cfgfile); err != nil {
+		return
+	}
+
+	logger.Infof("Received SIGHUP, reloading configuration from %s", cfgfile)
+	reloadConfig(cfgfile)
+}
+
+// reloadConfig reads cfgfile again and applies it to the listeners and the sessions.
+// An invalid config is ignored.
+func reloadConfig(cfgfile string) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	nv, err := config.LoadConfig(cfgfile)
+	if err != nil {
+		logger.Errorf("Keeping old configuration because %s could not be loaded: %v", cfgfile, err)
+		return
+	}
+
+	nv.BindPFlags(pflag.CommandLine)
+
+	// sessions read the old config meanwhile, it's replaced instead of changed.
+	vMu.Lock()
+	v = nv
+	vMu.Unlock()
+
+	for _, name := range []string{"tlsbind", "bind"} {
+		if err := listen(name); err != nil {
+			logger.Errorf("Keeping old %s: %v", name, err)
+		}
+	}
+
+	irckit.ReloadConfig(v)
+}
+
+func tlsbind(addr string) (net.Listener, error) {
 	certPath := v.GetString("tlsdir") + "/cert.pem"
 	keyPath := v.GetString("tlsdir") + "/key.pem"
 
@@ -159,28 +289,30 @@ func tlsbind() net.Listener {
 
 	kpr, err := NewKeypairReloader(certPath, keyPath)
 	if err != nil {
-		logger.Errorf("could not load TLS, incorrect directory? Error: %s", err)
-		os.Exit(1)
+		return nil, fmt.Errorf("could not load TLS, incorrect directory? Error: %w", err)
 	}
+	keypair = kpr
 
 	tlsConfig := tls.Config{
 		GetCertificate: kpr.GetCertificateFunc(),
 	}
 
-	listenerTLS, err := tls.Listen("tcp", v.GetString("tlsbind"), &tlsConfig)
+	listenerTLS, err := tls.Listen("tcp", addr, &tlsConfig)
 	if err != nil {
-		logger.Errorf("Can not listen on %s: %v\n", v.GetString("tlsbind"), err)
-		os.Exit(1)
+		return nil, err
 	}
 
-	logger.Info("TLS listening on ", v.GetString("tlsbind"))
+	logger.Info("TLS listening on ", addr)
 
-	return listenerTLS
+	return listenerTLS, nil
 }
 
 func start(socket net.Listener) {
 	for {
 		conn, err := socket.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
 		if err != nil {
 			logger.Errorf("Failed to accept connection: %v", err)
 			return
@@ -191,7 +323,11 @@ func start(socket net.Listener) {
 
 			logger.Infof("New connection: %s", conn.RemoteAddr())
 
-			user := irckit.NewUserBridge(conn, newsrv, v, LastViewedSaveDB)
+			vMu.RLock()
+			cfg := v
+			vMu.RUnlock()
+
+			user := irckit.NewUserBridge(conn, newsrv, cfg, LastViewedSaveDB)
 			err = newsrv.Connect(user)
 			if err != nil {
 				logger.Errorf("Failed to join: %v", err)
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestListen(t *testing.T) {
+	logger = logrus.NewEntry(logrus.New())
+	v = viper.New()
+	dir := t.TempDir()
+
+	defer func() {
+		for name, socket := range listeners {
+			socket.Close()
+			delete(listeners, name)
+			delete(listenAddrs, name)
+		}
+	}()
+
+	first := filepath.Join(dir, "first.sock")
+	v.Set("bind", first)
+	assert.NoError(t, listen("bind"))
+
+	socket := listeners["bind"]
+	assert.NotNil(t, socket)
+
+	// the same address keeps the listener.
+	assert.NoError(t, listen("bind"))
+	assert.Same(t, socket, listeners["bind"])
+
+	// a new address replaces it.
+	second := filepath.Join(dir, "second.sock")
+	v.Set("bind", second)
+	assert.NoError(t, listen("bind"))
+	assert.NotSame(t, socket, listeners["bind"])
+	assert.Equal(t, second, listenAddrs["bind"])
+
+	_, err := net.Dial("unix", first)
+	assert.Error(t, err, "the old listener is closed")
+
+	conn, err := net.Dial("unix", second)
+	if assert.NoError(t, err) {
+		conn.Close()
+	}
+
+	// one that doesn't work keeps the old one.
+	socket = listeners["bind"]
+	v.Set("bind", filepath.Join(dir, "missing", "third.sock"))
+	assert.Error(t, listen("bind"))
+	assert.Same(t, socket, listeners["bind"])
+	assert.Equal(t, second, listenAddrs["bind"])
+
+	// no address stops listening.
+	v.Set("bind", "")
+	assert.NoError(t, listen("bind"))
+	assert.NotContains(t, listeners, "bind")
+}
@@ -0,0 +1,296 @@
+package irckit
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sorcix/irc"
+	"github.com/spf13/viper"
+)
+
+// Operators are [opers.<name>] sections in the config with a bcrypt hash of their password, eg
+//
+//	[opers.alice]
+//	Password = "$2a$10$..."
+//
+// After /OPER alice <password> they can look after everyone using this matterircd by
+// messaging the admin service or talking in &admin.
+
+const (
+	adminNick    = "admin"
+	adminChannel = "&admin"
+)
+
+type adminCommand struct {
+	handler   func(u *User, to string, args []string, text string)
+	minParams int
+	maxParams int
+	usage     string
+}
+
+var adminCmds map[string]adminCommand
+
+func init() {
+	adminCmds = map[string]adminCommand{
+		"broadcast": {handler: adminBroadcast, minParams: 1, maxParams: -1, usage: "broadcast <text> - send a notice to all users"},
+		"debug":     {handler: adminDebug, minParams: 0, maxParams: 1, usage: "debug [on|off] - show or toggle debug logging"},
+		"help":      {handler: adminHelp, minParams: 0, maxParams: 0, usage: "help - show this list"},
+		"kill":      {handler: adminKill, minParams: 1, maxParams: -1, usage: "kill <number|nick> [reason] - disconnect a session"},
+		"queues":    {handler: adminQueues, minParams: 0, maxParams: 0, usage: "queues - show if the sessions keep up with their events"},
+		"sessions":  {handler: adminSessions, minParams: 0, maxParams: 0, usage: "sessions - list the sessions"},
+	}
+}
+
+// operFor returns the operator config of name, nil if there's none.
+func operFor(v *viper.Viper, name string) *viper.Viper {
+	if v == nil || name == "" || strings.Contains(name, ".") {
+		return nil
+	}
+
+	return v.Sub("opers." + strings.ToLower(name))
+}
+
+// becomeOper makes u operator name and joins it to &admin.
+func (u *User) becomeOper(name string) {
+	u.oper = name
+
+	logger.Infof("%s is now operator %s", u.Nick, name)
+
+	u.createService(adminNick, "adminservice")
+
+	// we can be operator before logging in, so no bridge to ask about the channel.
+	ch := u.Srv.NamedChannel(adminChannel, adminChannel)
+	if admin, ok := u.Srv.HasUserID(adminNick); ok {
+		ch.Join(admin) //nolint:errcheck
+	}
+
+	u.joinOwnChannel(ch)
+}
+
+// isAdminService tells if other is the admin service.
+func isAdminService(other *User) bool {
+	return other.Host == "service" && other.User == adminNick
+}
+
+// adminMsg sends text from the admin service to to (our nick or &admin).
+func (u *User) adminMsg(to string, text string) {
+	admin, ok := u.Srv.HasUserID(adminNick)
+	if !ok {
+		return
+	}
+
+	u.MsgSpoofUser(admin, to, text)
+}
+
+// handleAdmin runs the operator command in msg, replies go to to.
+func (u *User) handleAdmin(to string, msg string) {
+	if u.oper == "" {
+		u.adminMsg(to, "Permission denied, you need to /OPER first")
+		return
+	}
+
+	name, text, _ := strings.Cut(strings.TrimSpace(msg), " ")
+	text = strings.TrimSpace(text)
+	args := strings.Fields(text)
+
+	cmd, ok := adminCmds[strings.ToLower(name)]
+	if !ok {
+		adminHelp(u, to, nil, "")
+		return
+	}
+
+	if len(args) < cmd.minParams || (cmd.maxParams > -1 && len(args) > cmd.maxParams) {
+		u.adminMsg(to, "usage: "+cmd.usage)
+		return
+	}
+
+	logger.Infof("operator %s (%s): %s", u.oper, u.Nick, msg)
+
+	cmd.handler(u, to, args, text)
+}
+
+func adminHelp(u *User, to string, args []string, text string) {
+	names := make([]string, 0, len(adminCmds))
+	for name := range adminCmds {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		u.adminMsg(to, adminCmds[name].usage)
+	}
+}
+
+func adminSessions(u *User, to string, args []string, text string) {
+	list := sessions.listRunning()
+	for _, rs := range list {
+		u.adminMsg(to, sessionSummary(rs))
+	}
+
+	u.adminMsg(to, fmt.Sprintf("%d sessions", len(list)))
+}
+
+// sessionSummary describes rs on one line: number, nick, accounts, clients and uptime.
+func sessionSummary(rs runningSession) string {
+	accounts := []string{accountOf(rs.user)}
+	for _, network := range rs.user.getNetworks() {
+		accounts = append(accounts, "network="+network.network+" "+accountOf(network))
+	}
+
+	from := "detached"
+	if addrs := remoteAddrs(rs.user); len(addrs) > 0 {
+		from = "from " + strings.Join(addrs, ", ")
+	}
+
+	return fmt.Sprintf("%d: %s %s, %s, up %s", rs.id, rs.user.Nick, strings.Join(accounts, ", "), from,
+		time.Since(rs.started).Round(time.Second))
+}
+
+// accountOf returns the account and bridge u is logged in to.
+func accountOf(u *User) string {
+	if u.br == nil {
+		return "(not logged in)"
+	}
+
+	account := u.Credentials.Login
+	if account == "" {
+		account = u.br.GetMe().Nick
+	}
+
+	server := u.Credentials.Server
+	if u.Credentials.Team != "" {
+		server += "/" + u.Credentials.Team
+	}
+
+	return fmt.Sprintf("(%s %s %s)", u.br.Protocol(), account, strings.TrimPrefix(server, "/"))
+}
+
+// remoteAddrs returns the addresses of the clients of u.
+func remoteAddrs(u *User) []string {
+	sc, ok := u.Conn.(*sessionConn)
+	if !ok {
+		if u.Conn == nil {
+			return nil
+		}
+
+		return []string{remoteAddr(u.Conn)}
+	}
+
+	var addrs []string
+	for _, client := range sc.getClients() {
+		addrs = append(addrs, remoteAddr(client.Conn))
+	}
+
+	return addrs
+}
+
+func remoteAddr(c Conn) string {
+	if rc, ok := c.(interface{ RemoteAddr() net.Addr }); ok {
+		return rc.RemoteAddr().String()
+	}
+
+	return c.ResolveHost()
+}
+
+// findRunning returns the running session with number or nick id.
+func findRunning(id string) (runningSession, bool) {
+	number, err := strconv.Atoi(id)
+
+	for _, rs := range sessions.listRunning() {
+		if (err == nil && rs.id == number) || strings.EqualFold(rs.user.Nick, id) {
+			return rs, true
+		}
+	}
+
+	return runningSession{}, false
+}
+
+func adminKill(u *User, to string, args []string, text string) {
+	rs, ok := findRunning(args[0])
+	if !ok {
+		u.adminMsg(to, "no session "+args[0])
+		return
+	}
+
+	reason := "Killed by operator " + u.oper
+	if len(args) > 1 {
+		reason += ": " + strings.TrimSpace(strings.TrimPrefix(text, args[0]))
+	}
+
+	u.adminMsg(to, fmt.Sprintf("disconnecting session %d (%s)", rs.id, rs.user.Nick))
+
+	go rs.user.shutdown(reason)
+}
+
+func adminBroadcast(u *User, to string, args []string, text string) {
+	count := 0
+
+	for _, rs := range sessions.listRunning() {
+		if rs.user.Nick == "" {
+			continue
+		}
+
+		rs.user.Encode(&irc.Message{ //nolint:errcheck
+			Prefix:   &irc.Prefix{Name: adminNick, User: adminNick, Host: "service"},
+			Command:  irc.NOTICE,
+			Params:   []string{rs.user.Nick},
+			Trailing: text,
+		})
+
+		count++
+	}
+
+	u.adminMsg(to, fmt.Sprintf("sent to %d users", count))
+}
+
+func adminQueues(u *User, to string, args []string, text string) {
+	for _, rs := range sessions.listRunning() {
+		users := append([]*User{rs.user}, rs.user.getNetworks()...)
+		for _, user := range users {
+			name := fmt.Sprintf("%d: %s", rs.id, rs.user.Nick)
+			if user.network != "" {
+				name += " network=" + user.network
+			}
+
+			if user.br == nil {
+				u.adminMsg(to, name+" not logged in")
+				continue
+			}
+
+			// the bridges wait for the events to be handled, a busy session means they're waiting.
+			state := "idle"
+			if started := user.eventStarted.Load(); started != 0 {
+				state = "busy for " + time.Since(time.Unix(0, started)).Round(time.Second).String()
+			}
+
+			u.adminMsg(to, fmt.Sprintf("%s %d events handled, %s", name, user.eventsHandled.Load(), state))
+		}
+	}
+}
+
+func adminDebug(u *User, to string, args []string, text string) {
+	if len(args) == 1 {
+		switch strings.ToLower(args[0]) {
+		case "on":
+			setDebugLogging(true)
+		case "off":
+			setDebugLogging(false)
+		default:
+			u.adminMsg(to, "usage: "+adminCmds["debug"].usage)
+			return
+		}
+	}
+
+	state := "off"
+	if logger.Logger.IsLevelEnabled(logrus.DebugLevel) {
+		state = "on"
+	}
+
+	u.adminMsg(to, "debug logging is "+state)
+}
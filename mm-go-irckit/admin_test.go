@@ -0,0 +1,95 @@
+package irckit
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sorcix/irc"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestOper(t *testing.T) {
+	SetLogger(logrus.NewEntry(logrus.New()))
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	assert.NoError(t, err)
+
+	v := viper.New()
+	v.SetConfigType("toml")
+	assert.NoError(t, v.ReadConfig(strings.NewReader(`
+[opers.alice]
+Password = "`+string(hash)+`"
+`)))
+
+	assert.Nil(t, operFor(v, "bob"))
+	assert.NotNil(t, operFor(v, "Alice"))
+
+	c, client := newTestClient()
+	srv := NewServer("matterircd")
+	u := newBridgeUser(newSessionConn(c), srv, v, nil)
+	u.Nick, u.User = "me", "me"
+
+	go CmdOper(srv, u, &irc.Message{Command: irc.OPER, Params: []string{"alice", "wrong"}}) //nolint:errcheck
+	client.expect(t, ":matterircd 464 me :Password incorrect")
+
+	go CmdOper(srv, u, &irc.Message{Command: irc.OPER, Params: []string{"alice"}}) //nolint:errcheck
+	client.expect(t, ":matterircd 461 me OPER :Not enough parameters")
+
+	go CmdOper(srv, u, &irc.Message{Command: irc.OPER, Params: []string{"me"}, Trailing: "secret"}) //nolint:errcheck
+	client.expect(t, ":matterircd 464 me :Password incorrect")
+	assert.Empty(t, u.oper)
+
+	// not logged in yet.
+	go CmdOper(srv, u, &irc.Message{Command: irc.OPER, Params: []string{"Alice"}, Trailing: "secret"}) //nolint:errcheck
+	client.expect(t, ":me!me@* JOIN &admin")
+	client.expect(t, ":matterircd 353 me = &admin :admin me")
+	client.expect(t, ":matterircd 366 me &admin :End of /NAMES list.")
+	client.expect(t, ":matterircd 381 me :You are now an IRC operator")
+	assert.Equal(t, "alice", u.oper)
+
+	u.v = viper.New()
+	go CmdOper(srv, u, &irc.Message{Command: irc.OPER, Params: []string{"alice"}, Trailing: "secret"}) //nolint:errcheck
+	client.expect(t, ":matterircd 491 me :No O-lines for your host")
+}
+
+func TestAdminSessions(t *testing.T) {
+	SetLogger(logrus.NewEntry(logrus.New()))
+
+	c, client := newTestClient()
+	srv := NewServer("matterircd")
+	u := newBridgeUser(newSessionConn(c), srv, viper.New(), nil)
+	u.Nick, u.User = "me", "me"
+
+	other := newBridgeUser(nil, srv, viper.New(), nil)
+	other.Nick, other.User = "john", "john"
+
+	sessions.track(u)
+	defer sessions.untrack(u)
+	sessions.track(other)
+	defer sessions.untrack(other)
+
+	rs, ok := findRunning("JOHN")
+	assert.True(t, ok)
+	assert.Equal(t, other, rs.user)
+
+	byNumber, ok := findRunning(strconv.Itoa(rs.id))
+	assert.True(t, ok)
+	assert.Equal(t, rs, byNumber)
+
+	_, ok = findRunning("jane")
+	assert.False(t, ok)
+
+	assert.Regexp(t, `^\d+: john \(not logged in\), detached, up 0s$`, sessionSummary(rs))
+
+	u.createService(adminNick, "adminservice")
+	go u.handleAdmin(u.Nick, "sessions")
+	client.expect(t, ":admin!admin@service PRIVMSG me :Permission denied, you need to /OPER first")
+
+	u.oper = "alice"
+	go u.handleAdmin(u.Nick, "kill")
+	client.expect(t, ":admin!admin@service PRIVMSG me :usage: "+adminCmds["kill"].usage)
+}
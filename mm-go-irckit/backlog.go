@@ -0,0 +1,285 @@
+package irckit
+
+import (
+	"encoding/binary"
+	"strings"
+	"time"
+
+	"github.com/sorcix/irc"
+	bolt "go.etcd.io/bbolt"
+)
+
+// The backlog keeps the messages of a bouncer session (in the bucket of the logged in user),
+// so clients get what they missed when they attach again.
+// Clients are told apart by their username (USER), their position is the last message
+// they got before disconnecting.
+var (
+	backlogBucket          = []byte("backlog")
+	backlogPositionsBucket = []byte("backlogpositions")
+)
+
+// backlogCommands are the commands kept in the backlog, replies to commands aren't.
+var backlogCommands = map[string]bool{
+	irc.PRIVMSG: true,
+	irc.NOTICE:  true,
+	irc.JOIN:    true,
+	irc.PART:    true,
+	irc.KICK:    true,
+	irc.QUIT:    true,
+	irc.TOPIC:   true,
+	irc.NICK:    true,
+}
+
+const (
+	defaultBacklogSize   = 1000
+	defaultBacklogMaxAge = 24
+)
+
+func seqKey(seq uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, seq)
+
+	return key
+}
+
+// backlogLimits returns the maximum number of messages and the maximum age of the backlog.
+// A size of 0 disables the backlog, an age of 0 means no age limit.
+func (u *User) backlogLimits() (int, time.Duration) {
+	size, maxAge := defaultBacklogSize, defaultBacklogMaxAge
+
+	if u.config().IsSet("backlogsize") {
+		size = u.config().GetInt("backlogsize")
+	}
+
+	if u.config().IsSet("backlogmaxage") {
+		maxAge = u.config().GetInt("backlogmaxage")
+	}
+
+	return size, time.Duration(maxAge) * time.Hour
+}
+
+// recordBacklog adds msg to the backlog and returns its sequence number.
+func (u *User) recordBacklog(msg *irc.Message) (uint64, bool) {
+	if u.lastViewedAtDB == nil || u.User == "" || msg.Prefix == nil || !backlogCommands[msg.Command] {
+		return 0, false
+	}
+
+	// don't keep logins and tokens.
+	if msg.Prefix.Host == "service" {
+		return 0, false
+	}
+
+	size, maxAge := u.backlogLimits()
+	if size <= 0 {
+		return 0, false
+	}
+
+	now := time.Now()
+
+	value := make([]byte, 8, 8+len(msg.String()))
+	binary.BigEndian.PutUint64(value, uint64(now.Unix()))
+	value = append(value, msg.String()...)
+
+	var seq uint64
+
+	err := u.lastViewedAtDB.Batch(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(u.User))
+		if err != nil {
+			return err
+		}
+
+		bb, err := b.CreateBucketIfNotExists(backlogBucket)
+		if err != nil {
+			return err
+		}
+
+		seq, err = bb.NextSequence()
+		if err != nil {
+			return err
+		}
+
+		if err = bb.Put(seqKey(seq), value); err != nil {
+			return err
+		}
+
+		return pruneBacklog(bb, seq, size, maxAge, now)
+	})
+	if err != nil {
+		logger.Errorf("saving backlog failed: %s", err)
+		return 0, false
+	}
+
+	return seq, true
+}
+
+// pruneBacklog removes the messages which are too old or too many.
+func pruneBacklog(bb *bolt.Bucket, last uint64, size int, maxAge time.Duration, now time.Time) error {
+	c := bb.Cursor()
+
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		seq := binary.BigEndian.Uint64(k)
+		tooMany := last-seq >= uint64(size)
+		tooOld := maxAge > 0 && len(v) >= 8 && now.Sub(time.Unix(int64(binary.BigEndian.Uint64(v)), 0)) > maxAge
+
+		if !tooMany && !tooOld {
+			return nil
+		}
+
+		if err := c.Delete(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// backlog returns the messages after sequence number from, with their time added.
+func (u *User) backlog(from uint64) []*irc.Message {
+	if u.lastViewedAtDB == nil || u.User == "" {
+		return nil
+	}
+
+	var msgs []*irc.Message
+
+	now := time.Now()
+
+	err := u.lastViewedAtDB.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(u.User))
+		if b == nil {
+			return nil
+		}
+
+		bb := b.Bucket(backlogBucket)
+		if bb == nil {
+			return nil
+		}
+
+		c := bb.Cursor()
+		for k, v := c.Seek(seqKey(from + 1)); k != nil; k, v = c.Next() {
+			if len(v) < 8 {
+				continue
+			}
+
+			msg := irc.ParseMessage(string(v[8:]))
+			if msg == nil {
+				continue
+			}
+
+			addTimestamp(msg, time.Unix(int64(binary.BigEndian.Uint64(v)), 0), now)
+			msgs = append(msgs, msg)
+		}
+
+		return nil
+	})
+	if err != nil {
+		logger.Errorf("loading backlog failed: %s", err)
+	}
+
+	return msgs
+}
+
+// addTimestamp adds the time t a message was sent to its text.
+func addTimestamp(msg *irc.Message, t time.Time, now time.Time) {
+	if msg.Command != irc.PRIVMSG && msg.Command != irc.NOTICE {
+		return
+	}
+
+	layout := "[15:04] "
+	if t.YearDay() != now.YearDay() || t.Year() != now.Year() {
+		layout = "[Jan 02 15:04] "
+	}
+
+	stamp := t.Format(layout)
+
+	if strings.HasPrefix(msg.Trailing, "\x01ACTION ") {
+		msg.Trailing = "\x01ACTION " + stamp + strings.TrimPrefix(msg.Trailing, "\x01ACTION ")
+		return
+	}
+
+	msg.Trailing = stamp + msg.Trailing
+}
+
+func (u *User) saveBacklogPosition(id string, seq uint64) {
+	if u.lastViewedAtDB == nil || u.User == "" {
+		return
+	}
+
+	err := u.lastViewedAtDB.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(u.User))
+		if err != nil {
+			return err
+		}
+
+		pb, err := b.CreateBucketIfNotExists(backlogPositionsBucket)
+		if err != nil {
+			return err
+		}
+
+		return pb.Put([]byte(strings.ToLower(id)), seqKey(seq))
+	})
+	if err != nil {
+		logger.Errorf("saving backlog position of %s failed: %s", id, err)
+	}
+}
+
+func (u *User) backlogPosition(id string) (uint64, bool) {
+	if u.lastViewedAtDB == nil || u.User == "" {
+		return 0, false
+	}
+
+	var (
+		seq   uint64
+		found bool
+	)
+
+	err := u.lastViewedAtDB.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(u.User))
+		if b == nil {
+			return nil
+		}
+
+		if pb := b.Bucket(backlogPositionsBucket); pb != nil {
+			if v := pb.Get([]byte(strings.ToLower(id))); len(v) == 8 {
+				seq, found = binary.BigEndian.Uint64(v), true
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		logger.Errorf("loading backlog position of %s failed: %s", id, err)
+	}
+
+	return seq, found
+}
+
+// replayBacklog sends the messages client missed since it was last attached.
+// Clients we don't know get the messages since the session was detached.
+func (u *User) replayBacklog(client *clientConn) {
+	sc, ok := u.Conn.(*sessionConn)
+	if !ok {
+		return
+	}
+
+	from, ok := u.backlogPosition(client.getID())
+	if !ok {
+		from, ok = sc.detachedPosition()
+	}
+
+	if !ok {
+		return
+	}
+
+	msgs := u.backlog(from)
+
+	logger.Debugf("replaying %d backlog messages to %s", len(msgs), client.getID())
+
+	for _, msg := range msgs {
+		// the client already got our current nick and channels.
+		if msg.Prefix != nil && msg.Prefix.Name == u.Nick && msg.Command != irc.PRIVMSG && msg.Command != irc.NOTICE {
+			continue
+		}
+
+		client.Encode(msg) //nolint:errcheck
+	}
+}
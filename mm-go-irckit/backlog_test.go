@@ -0,0 +1,67 @@
+package irckit
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/sorcix/irc"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBacklog(t *testing.T) {
+	u := NewUser(nil)
+	u.User = "me"
+	u.v = viper.New()
+	u.v.Set("backlogsize", 3)
+	u.lastViewedAtDB = newTestDB(t)
+
+	from := &irc.Prefix{Name: "john", User: "john", Host: "host"}
+
+	_, ok := u.recordBacklog(&irc.Message{Prefix: from, Command: irc.RPL_WHOISUSER, Params: []string{"me"}})
+	assert.False(t, ok, "replies aren't kept")
+
+	_, ok = u.recordBacklog(&irc.Message{Prefix: &irc.Prefix{Name: "mattermost", Host: "service"}, Command: irc.PRIVMSG, Params: []string{"me"}, Trailing: "token used: secret"})
+	assert.False(t, ok, "services aren't kept")
+
+	var last uint64
+	for i := 1; i <= 5; i++ {
+		last, ok = u.recordBacklog(&irc.Message{Prefix: from, Command: irc.PRIVMSG, Params: []string{"#test"}, Trailing: fmt.Sprintf("msg %d", i)})
+		assert.True(t, ok)
+	}
+
+	msgs := u.backlog(0)
+	assert.Len(t, msgs, 3)
+	assert.Regexp(t, `^\[\d\d:\d\d\] msg 3$`, msgs[0].Trailing)
+	assert.Regexp(t, `^\[\d\d:\d\d\] msg 5$`, msgs[2].Trailing)
+
+	msgs = u.backlog(last - 1)
+	assert.Len(t, msgs, 1)
+	assert.Regexp(t, `^:john!john@host PRIVMSG #test :\[\d\d:\d\d\] msg 5$`, msgs[0].String())
+
+	_, ok = u.backlogPosition("weechat")
+	assert.False(t, ok)
+
+	u.saveBacklogPosition("WeeChat", last)
+	pos, ok := u.backlogPosition("weechat")
+	assert.True(t, ok)
+	assert.Equal(t, last, pos)
+	assert.Empty(t, u.backlog(pos))
+}
+
+func TestAddTimestamp(t *testing.T) {
+	now := time.Date(2024, 3, 10, 12, 0, 0, 0, time.Local)
+
+	msg := &irc.Message{Command: irc.PRIVMSG, Trailing: "\x01ACTION waves\x01"}
+	addTimestamp(msg, now.Add(-time.Hour), now)
+	assert.Equal(t, "\x01ACTION [11:00] waves\x01", msg.Trailing)
+
+	msg = &irc.Message{Command: irc.NOTICE, Trailing: "hi"}
+	addTimestamp(msg, now.Add(-24*time.Hour), now)
+	assert.Equal(t, "[Mar 09 12:00] hi", msg.Trailing)
+
+	msg = &irc.Message{Command: irc.TOPIC, Trailing: "topic"}
+	addTimestamp(msg, now, now)
+	assert.Equal(t, "topic", msg.Trailing)
+}
@@ -0,0 +1,224 @@
+package irckit
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/42wim/matterircd/bridge"
+)
+
+// Our channels are organised in categories (the mattermost sidebar). They're joined in the
+// order of the categories and JoinOnly, JoinInclude and JoinExclude can have category:<name>
+// entries, eg JoinOnly = ["category:Favorites", "#town-square"].
+
+const categoryPrefix = "category:"
+
+// categoryChannelsPerLine is the number of channels shown per line by CATEGORIES.
+const categoryChannelsPerLine = 15
+
+// categoryKeeper returns the bridge of u if it has categories.
+func (u *User) categoryKeeper() (bridge.CategoryKeeper, bool) {
+	keeper, ok := u.br.(bridge.CategoryKeeper)
+
+	return keeper, ok
+}
+
+// loadCategories gets our categories.
+func (u *User) loadCategories() {
+	keeper, ok := u.categoryKeeper()
+	if !ok {
+		return
+	}
+
+	categories, err := keeper.GetCategories()
+	if err != nil {
+		logger.Errorf("getting the categories of %s failed: %s", u.Nick, err)
+		return
+	}
+
+	u.categoriesMutex.Lock()
+	u.categories = categories
+	u.categoriesMutex.Unlock()
+}
+
+// categoriesOf returns the names of the categories channelID is in.
+func (u *User) categoriesOf(channelID string) []string {
+	u.categoriesMutex.RLock()
+	defer u.categoriesMutex.RUnlock()
+
+	var names []string
+
+	for _, c := range u.categories {
+		for _, id := range c.ChannelIDs {
+			if id == channelID {
+				names = append(names, c.Name)
+				break
+			}
+		}
+	}
+
+	return names
+}
+
+// sortByCategory sorts channels in the order of categories, the ones in none of them last.
+func sortByCategory(channels []*bridge.ChannelInfo, categories []*bridge.CategoryInfo) {
+	position := make(map[string]int)
+
+	for _, c := range categories {
+		for _, id := range c.ChannelIDs {
+			// DMs are in the categories of every team.
+			if _, ok := position[id]; !ok {
+				position[id] = len(position)
+			}
+		}
+	}
+
+	sort.SliceStable(channels, func(i, j int) bool {
+		pi, iok := position[channels[i].ID]
+		pj, jok := position[channels[j].ID]
+
+		switch {
+		case iok && jok:
+			return pi < pj
+		default:
+			return iok && !jok
+		}
+	})
+}
+
+// sortChannels sorts channels in the order of our categories.
+func (u *User) sortChannels(channels []*bridge.ChannelInfo) {
+	u.categoriesMutex.RLock()
+	defer u.categoriesMutex.RUnlock()
+
+	sortByCategory(channels, u.categories)
+}
+
+// matchJoin tells if the channel named name, in categories, matches one of the entries of a
+// join setting: a category:<name> entry or a regexp.
+func matchJoin(name string, categories []string, list []string) bool {
+	var regexps []string
+
+	for _, entry := range list {
+		if !strings.HasPrefix(strings.ToLower(entry), categoryPrefix) {
+			regexps = append(regexps, entry)
+			continue
+		}
+
+		for _, category := range categories {
+			if strings.EqualFold(category, entry[len(categoryPrefix):]) {
+				return true
+			}
+		}
+	}
+
+	return stringInRegexp(name, regexps)
+}
+
+// usesCategories tells if one of the join settings has a category:<name> entry.
+func (u *User) usesCategories() bool {
+	for _, key := range []string{"joinonly", "joininclude", "joinexclude"} {
+		for _, entry := range u.config().GetStringSlice(u.br.Protocol() + "." + key) {
+			if strings.HasPrefix(strings.ToLower(entry), categoryPrefix) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func (u *User) handleCategoriesEvent() {
+	u.loadCategories()
+
+	if u.usesCategories() {
+		u.rejoinChannels()
+	}
+}
+
+func categories(u *User, toUser *User, args []string, service string) {
+	keeper, ok := u.categoryKeeper()
+	if !ok {
+		u.MsgUser(toUser, "categories are not supported on "+service)
+		return
+	}
+
+	switch len(args) {
+	case 0:
+		listCategories(u, toUser)
+	case 1:
+		u.MsgUser(toUser, "need "+commandUsage("categories", service))
+	default:
+		moveChannel(u, toUser, keeper, args[0], strings.Join(args[1:], " "))
+	}
+}
+
+func listCategories(u *User, toUser *User) {
+	u.loadCategories()
+
+	u.categoriesMutex.RLock()
+	list := u.categories
+	u.categoriesMutex.RUnlock()
+
+	teams := make(map[string]bool)
+	for _, c := range list {
+		teams[c.TeamID] = true
+	}
+
+	for _, c := range list {
+		name := c.Name
+		if len(teams) > 1 {
+			name += " (" + u.br.GetTeamName(c.TeamID) + ")"
+		}
+
+		if len(c.ChannelIDs) == 0 {
+			u.MsgUser(toUser, name+": -")
+			continue
+		}
+
+		names := make([]string, 0, len(c.ChannelIDs))
+		for _, id := range c.ChannelIDs {
+			names = append(names, u.channelDisplayName(id))
+		}
+
+		for i := 0; i < len(names); i += categoryChannelsPerLine {
+			end := i + categoryChannelsPerLine
+			if end > len(names) {
+				end = len(names)
+			}
+
+			u.MsgUser(toUser, fmt.Sprintf("%s: %s", name, strings.Join(names[i:end], ", ")))
+		}
+	}
+
+	u.MsgUser(toUser, "move a channel with CATEGORIES #<channel>|<user> <category>")
+}
+
+func moveChannel(u *User, toUser *User, keeper bridge.CategoryKeeper, name, category string) {
+	var channelID string
+
+	if strings.HasPrefix(name, "#") {
+		channelID = u.br.GetChannelID(strings.TrimPrefix(name, "#"), u.br.GetMe().TeamID)
+	} else if other, exists := u.Srv.HasUser(name); exists && other.Ghost {
+		channelID = u.dmChannelID(other)
+	}
+
+	if channelID == "" {
+		u.MsgUser(toUser, "channel or user "+name+" does not exist")
+		return
+	}
+
+	if err := keeper.MoveChannel(channelID, category); err != nil {
+		u.MsgUser(toUser, "moving "+name+" failed: "+err.Error())
+		return
+	}
+
+	u.loadCategories()
+
+	if brchannel, err := u.br.GetChannel(channelID); err == nil && !brchannel.DM {
+		u.rejoinChannel(brchannel)
+	}
+
+	u.MsgUser(toUser, "moved "+name+" to "+category)
+}
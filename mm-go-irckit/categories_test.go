@@ -0,0 +1,46 @@
+package irckit
+
+import (
+	"testing"
+
+	"github.com/42wim/matterircd/bridge"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSortByCategory(t *testing.T) {
+	channels := []*bridge.ChannelInfo{{ID: "a"}, {ID: "b"}, {ID: "c"}, {ID: "dm"}, {ID: "d"}, {ID: "e"}}
+	categories := []*bridge.CategoryInfo{
+		{Name: "Favorites", ChannelIDs: []string{"d", "b"}},
+		{Name: "Channels", ChannelIDs: []string{"a", "c"}},
+		{Name: "Direct Messages", ChannelIDs: []string{"dm"}},
+		{Name: "Favorites", ChannelIDs: []string{"c"}},
+		{Name: "Direct Messages", ChannelIDs: []string{"dm"}},
+	}
+
+	sortByCategory(channels, categories)
+
+	ids := make([]string, 0, len(channels))
+	for _, c := range channels {
+		ids = append(ids, c.ID)
+	}
+
+	assert.Equal(t, []string{"d", "b", "a", "c", "dm", "e"}, ids)
+}
+
+func TestMatchJoin(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		categories []string
+		list       []string
+		want       bool
+	}{
+		{"#bugs", []string{"Favorites"}, []string{"category:favorites"}, true},
+		{"#bugs", []string{"Channels"}, []string{"category:Favorites"}, false},
+		{"#bugs", nil, []string{"category:Favorites", "#bu.*"}, true},
+		{"#bugs", []string{"Team Ops"}, []string{"Category:Team Ops"}, true},
+		{"#ops", []string{"Channels"}, []string{"#bugs"}, false},
+		{"#ops", nil, nil, false},
+	} {
+		assert.Equal(t, tc.want, matchJoin(tc.name, tc.categories, tc.list), tc)
+	}
+}
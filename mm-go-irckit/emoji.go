@@ -0,0 +1,204 @@
+package irckit
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+// The emoji table is the one mattermost uses, it contains the (slack compatible) shortcodes,
+// their aliases and all skin tone variants.
+
+const variationSelector = '\ufe0f'
+
+// slack writes skin tones as a separate modifier, eg :+1::skin-tone-2:
+var skinTones = map[rune]string{
+	'\U0001f3fb': "skin-tone-2",
+	'\U0001f3fc': "skin-tone-3",
+	'\U0001f3fd': "skin-tone-4",
+	'\U0001f3fe': "skin-tone-5",
+	'\U0001f3ff': "skin-tone-6",
+}
+
+var emojiShortcodeRegExp = regexp.MustCompile(`:([a-z0-9_+\-]+)(?:::(skin-tone-[2-6]))?:`)
+
+var (
+	emojiOnce          sync.Once
+	emojiUnicode       map[string]string
+	emojiReverse       map[string]string
+	emojiReplacer      *strings.Replacer
+	emojiSlackReplacer *strings.Replacer
+)
+
+func loadEmoji() {
+	emojiUnicode = make(map[string]string)
+	emojiReverse = make(map[string]string)
+
+	for name, code := range model.SystemEmojis {
+		e, ok := emojiFromCode(code)
+		if !ok {
+			continue
+		}
+
+		emojiUnicode[name] = e
+
+		// use the same name mattermost picks for this emoji.
+		if _, ok := emojiReverse[e]; !ok {
+			canonical, _ := model.GetEmojiNameFromUnicode(code)
+			emojiReverse[e] = canonical
+		}
+	}
+
+	// irc clients don't always send the variation selector, but only match those
+	// without it when they can't be confused with normal text (eg © or digits).
+	for e, name := range emojiReverse {
+		stripped := strings.ReplaceAll(e, string(variationSelector), "")
+		r, _ := utf8.DecodeRuneInString(stripped)
+		if stripped == e || r < 0x2600 {
+			continue
+		}
+		if _, ok := emojiReverse[stripped]; !ok {
+			emojiReverse[stripped] = name
+		}
+	}
+
+	// longest sequences first, so skin tones and ZWJ sequences aren't split up.
+	sequences := make([]string, 0, len(emojiReverse))
+	for e := range emojiReverse {
+		sequences = append(sequences, e)
+	}
+
+	sort.Slice(sequences, func(i, j int) bool {
+		if len(sequences[i]) != len(sequences[j]) {
+			return len(sequences[i]) > len(sequences[j])
+		}
+		return sequences[i] < sequences[j]
+	})
+
+	oldnew := make([]string, 0, len(sequences)*2)
+	slackOldnew := make([]string, 0, len(sequences)*2)
+	for _, e := range sequences {
+		oldnew = append(oldnew, e, ":"+emojiReverse[e]+":")
+		slackOldnew = append(slackOldnew, e, ":"+slackShortcode(e)+":")
+	}
+
+	emojiReplacer = strings.NewReplacer(oldnew...)
+	emojiSlackReplacer = strings.NewReplacer(slackOldnew...)
+}
+
+// slackShortcode returns the shortcode for e with the skin tone as a separate modifier.
+func slackShortcode(e string) string {
+	for r, tone := range skinTones {
+		if strings.Count(e, string(r)) != 1 {
+			continue
+		}
+
+		if base, ok := emojiReverse[strings.ReplaceAll(e, string(r), "")]; ok {
+			return base + "::" + tone
+		}
+	}
+
+	return emojiReverse[e]
+}
+
+// emojiFromCode converts a mattermost emoji code (eg 1f44d-1f3fb) to unicode.
+func emojiFromCode(code string) (string, bool) {
+	var b strings.Builder
+
+	for _, c := range strings.Split(code, "-") {
+		r, err := strconv.ParseInt(c, 16, 32)
+		if err != nil {
+			return "", false
+		}
+		b.WriteRune(rune(r))
+	}
+
+	return b.String(), true
+}
+
+// emojiToUnicode returns the unicode emoji for shortcode name (without colons).
+// Slack style skin tones (eg +1::skin-tone-2) are also supported.
+func emojiToUnicode(name string) (string, bool) {
+	emojiOnce.Do(loadEmoji)
+
+	base, tone, _ := strings.Cut(name, "::")
+
+	e, ok := emojiUnicode[base]
+	if !ok {
+		return "", false
+	}
+
+	if tone == "" {
+		return e, true
+	}
+
+	for r, t := range skinTones {
+		if t != tone {
+			continue
+		}
+
+		// the toned variant replaces the variation selector.
+		toned := strings.TrimSuffix(e, string(variationSelector)) + string(r)
+		if _, ok := emojiReverse[toned]; ok {
+			return toned, true
+		}
+	}
+
+	return e, true
+}
+
+// emojiToShortcode returns the shortcode name (without colons) for the unicode emoji e.
+// When slack is set skin tones are returned slack style (eg +1::skin-tone-2).
+func emojiToShortcode(e string, slack bool) (string, bool) {
+	emojiOnce.Do(loadEmoji)
+
+	name, ok := emojiReverse[e]
+	if !ok || !slack {
+		return name, ok
+	}
+
+	return slackShortcode(e), true
+}
+
+// replaceEmojiShortcodes replaces all known :shortcodes: in text with their unicode emoji.
+func replaceEmojiShortcodes(text string) string {
+	if !strings.Contains(text, ":") {
+		return text
+	}
+
+	return emojiShortcodeRegExp.ReplaceAllStringFunc(text, func(m string) string {
+		if e, ok := emojiToUnicode(strings.Trim(m, ":")); ok {
+			return e
+		}
+		return m
+	})
+}
+
+// emojiShortcodes replaces the unicode emoji in text we send with their :shortcode: on the
+// protocols that use them (mattermost and slack).
+func (u *User) emojiShortcodes(text string) string {
+	switch u.br.Protocol() {
+	case "mattermost":
+		return replaceEmojiUnicode(text, false)
+	case "slack":
+		return replaceEmojiUnicode(text, true)
+	default:
+		return text
+	}
+}
+
+// replaceEmojiUnicode replaces all unicode emoji in text with their :shortcode:.
+func replaceEmojiUnicode(text string, slack bool) string {
+	emojiOnce.Do(loadEmoji)
+
+	if slack {
+		return emojiSlackReplacer.Replace(text)
+	}
+
+	return emojiReplacer.Replace(text)
+}
@@ -0,0 +1,34 @@
+package irckit
+
+import (
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplaceEmojiShortcodes(t *testing.T) {
+	assert.Equal(t, "💰 🤑 at 12:30:45", replaceEmojiShortcodes(":moneybag: :money_mouth_face: at 12:30:45"))
+	assert.Equal(t, "👍 👍 👍🏻 👍🏻", replaceEmojiShortcodes(":+1: :thumbsup: :+1_light_skin_tone: :+1::skin-tone-2:"))
+	assert.Equal(t, ":notanemoji:", replaceEmojiShortcodes(":notanemoji:"))
+}
+
+func TestReplaceEmojiUnicode(t *testing.T) {
+	assert.Equal(t, "@@abc +:+1:", replaceEmojiUnicode("@@abc +👍", false))
+	assert.Equal(t, ":+1_light_skin_tone: :heart:", replaceEmojiUnicode("👍🏻 ❤️", false))
+	assert.Equal(t, ":+1::skin-tone-2: :heart:", replaceEmojiUnicode("👍🏻 ❤", true))
+	assert.Equal(t, "© 2023 #1", replaceEmojiUnicode("© 2023 #1", false))
+}
+
+func TestEmojiShortcodes(t *testing.T) {
+	u := newBridgeUser(nil, nil, viper.New(), nil)
+
+	for protocol, want := range map[string]string{
+		"mattermost": "nice :+1_light_skin_tone:",
+		"slack":      "nice :+1::skin-tone-2:",
+		"mastodon":   "nice 👍🏻",
+	} {
+		u.br = &protocolBridge{protocol: protocol}
+		assert.Equal(t, want, u.emojiShortcodes("nice 👍🏻"), protocol)
+	}
+}
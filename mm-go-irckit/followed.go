@@ -0,0 +1,207 @@
+package irckit
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	"github.com/42wim/matterircd/bridge"
+)
+
+// With collapsed reply threads (mattermost) we follow threads: the ones we started, replied to
+// or were mentioned in, or followed ourselves. With threadsonly the replies of the threads we
+// follow are shown in &threads and the other replies are hidden.
+
+const threadsChannel = "&threads"
+
+// threadKeeper returns the bridge of u if it has collapsed reply threads.
+func (u *User) threadKeeper() (bridge.ThreadKeeper, bool) {
+	keeper, ok := u.br.(bridge.ThreadKeeper)
+
+	return keeper, ok
+}
+
+// loadFollowed fetches the threads we follow (at login and after a reconnect).
+func (u *User) loadFollowed() {
+	keeper, ok := u.threadKeeper()
+	if !ok {
+		return
+	}
+
+	threads, err := keeper.GetThreads(false)
+	if err != nil {
+		logger.Errorf("getting the followed threads of %s failed: %s", u.Nick, err)
+		return
+	}
+
+	followed := make(map[string]bool, len(threads))
+	for _, t := range threads {
+		followed[t.RootID] = true
+	}
+
+	u.followedMutex.Lock()
+	u.followed = followed
+	u.followedMutex.Unlock()
+}
+
+// isFollowed tells if we follow the thread of rootID, known is false when the threads we follow
+// couldn't be fetched, then they're fetched again in the background.
+func (u *User) isFollowed(rootID string) (following bool, known bool) {
+	u.followedMutex.Lock()
+	defer u.followedMutex.Unlock()
+
+	if u.followed == nil {
+		if atomic.CompareAndSwapInt32(&u.followedLoading, 0, 1) {
+			go func() {
+				defer atomic.StoreInt32(&u.followedLoading, 0)
+				u.loadFollowed()
+			}()
+		}
+
+		return false, false
+	}
+
+	return u.followed[rootID], true
+}
+
+// setFollowed remembers whether we follow the thread of rootID.
+func (u *User) setFollowed(rootID string, following bool) {
+	u.followedMutex.Lock()
+	defer u.followedMutex.Unlock()
+
+	// not fetched yet, it will be up to date when it is.
+	if u.followed == nil {
+		return
+	}
+
+	if following {
+		u.followed[rootID] = true
+	} else {
+		delete(u.followed, rootID)
+	}
+}
+
+func (u *User) handleThreadFollowEvent(event *bridge.ThreadFollowEvent) {
+	u.setFollowed(event.RootID, event.Following)
+}
+
+// threadReplied marks the thread of rootID (or of a reply in it) as read after we replied,
+// replying follows it too.
+func (u *User) threadReplied(rootID string) {
+	keeper, ok := u.threadKeeper()
+	if !ok {
+		return
+	}
+
+	u.setFollowed(rootID, true)
+
+	go func() {
+		if err := keeper.UpdateThreadRead(rootID); err != nil {
+			logger.Errorf("marking thread %s as read failed: %s", rootID, err)
+		}
+	}()
+}
+
+// showFollowedReply shows the reply of event from nick in &threads if we follow its thread.
+// It returns false when we don't know whether we follow it, the reply isn't handled then.
+func (u *User) showFollowedReply(nick string, event *bridge.ChannelMessageEvent) bool {
+	if event.Sender.Me {
+		u.setFollowed(event.ParentID, true)
+	}
+
+	following, known := u.isFollowed(event.ParentID)
+	if !known {
+		return false
+	}
+
+	if !following {
+		logger.Debugf("Not showing reply %s of unfollowed thread %s", event.MessageID, event.ParentID)
+		return true
+	}
+
+	u.rememberVirtualSource(event.MessageID, event.ChannelID)
+	u.rememberVirtualSource(event.ParentID, event.ChannelID)
+
+	u.spoofChannelMessage(u.Srv.Channel(threadsChannel), nick+"/"+u.Srv.Channel(event.ChannelID).String(), threadsChannel, event)
+
+	return true
+}
+
+func threads(u *User, toUser *User, args []string, service string) {
+	keeper, ok := u.threadKeeper()
+	if !ok {
+		u.MsgUser(toUser, "threads are not supported on "+service)
+		return
+	}
+
+	unreadOnly := len(args) == 1 && strings.EqualFold(args[0], "unread")
+
+	list, err := keeper.GetThreads(unreadOnly)
+	if err != nil {
+		u.MsgUser(toUser, "getting threads failed: "+err.Error())
+		return
+	}
+
+	unread := 0
+
+	for _, t := range list {
+		nick := ""
+		if user := u.br.GetUser(t.UserID); user != nil {
+			nick = u.ghostNick(user) + ": "
+		}
+
+		text := []rune(strings.SplitN(t.Message, "\n", 2)[0])
+		if len(text) > 60 {
+			text = append(text[:60], '…')
+		}
+
+		line := fmt.Sprintf("@@%s %s %s%s (%d replies", t.RootID, u.br.GetChannelName(t.ChannelID), nick, string(text), t.ReplyCount)
+		if t.UnreadReplies > 0 {
+			line += fmt.Sprintf(", %d unread", t.UnreadReplies)
+			unread++
+		}
+
+		if t.UnreadMentions > 0 {
+			line += fmt.Sprintf(", %d mentions", t.UnreadMentions)
+		}
+
+		u.MsgUser(toUser, line+")")
+	}
+
+	u.MsgUser(toUser, fmt.Sprintf("%d threads, %d unread", len(list), unread))
+}
+
+func follow(u *User, toUser *User, args []string, service string) {
+	followThread(u, toUser, args[0], true)
+}
+
+func unfollow(u *User, toUser *User, args []string, service string) {
+	followThread(u, toUser, args[0], false)
+}
+
+func followThread(u *User, toUser *User, id string, following bool) {
+	keeper, ok := u.threadKeeper()
+	if !ok {
+		u.MsgUser(toUser, "threads are not supported on "+u.br.Protocol())
+		return
+	}
+
+	postID := threadPostID(id)
+	if postID == "" {
+		u.MsgUser(toUser, id+" is not a post ID, use @@<post ID> or a permalink")
+		return
+	}
+
+	if err := keeper.FollowThread(postID, following); err != nil {
+		u.MsgUser(toUser, "changing thread "+postID+" failed: "+err.Error())
+		return
+	}
+
+	u.setFollowed(postID, following)
+
+	if following {
+		u.MsgUser(toUser, "following thread "+postID)
+	} else {
+		u.MsgUser(toUser, "not following thread "+postID+" anymore")
+	}
+}
@@ -0,0 +1,73 @@
+package irckit
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/42wim/matterircd/bridge"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+type threadsBridge struct {
+	protocolBridge
+	threads []*bridge.ThreadInfo
+	err     error
+}
+
+func (b *threadsBridge) GetThreads(unreadOnly bool) ([]*bridge.ThreadInfo, error) {
+	return b.threads, b.err
+}
+
+func (b *threadsBridge) FollowThread(rootID string, follow bool) error {
+	return nil
+}
+
+func (b *threadsBridge) UpdateThreadRead(rootID string) error {
+	return nil
+}
+
+func TestFollowed(t *testing.T) {
+	br := &threadsBridge{
+		protocolBridge: protocolBridge{protocol: "mattermost"},
+		threads:        []*bridge.ThreadInfo{{RootID: "root1"}, {RootID: "root2"}},
+	}
+
+	u := newBridgeUser(nil, nil, viper.New(), nil)
+	u.br = br
+
+	// not fetched yet, nothing to remember.
+	u.setFollowed("root3", true)
+	assert.Nil(t, u.followed)
+
+	u.loadFollowed()
+
+	following, known := u.isFollowed("root1")
+	assert.True(t, following)
+	assert.True(t, known)
+
+	following, _ = u.isFollowed("root3")
+	assert.False(t, following)
+
+	u.handleThreadFollowEvent(&bridge.ThreadFollowEvent{RootID: "root3", Following: true})
+	u.handleThreadFollowEvent(&bridge.ThreadFollowEvent{RootID: "root1", Following: false})
+
+	for rootID, want := range map[string]bool{"root1": false, "root2": true, "root3": true} {
+		following, _ = u.isFollowed(rootID)
+		assert.Equal(t, want, following, rootID)
+	}
+}
+
+func TestFollowedFetchFailed(t *testing.T) {
+	SetLogger(logrus.NewEntry(logrus.New()))
+
+	u := newBridgeUser(nil, nil, viper.New(), nil)
+	u.br = &threadsBridge{protocolBridge: protocolBridge{protocol: "mattermost"}, err: errors.New("timeout")}
+
+	u.loadFollowed()
+	assert.Nil(t, u.followed, "fetched again later")
+
+	_, known := u.isFollowed("root1")
+	assert.False(t, known)
+}
@@ -0,0 +1,113 @@
+package irckit
+
+import (
+	"regexp"
+	"strings"
+)
+
+// With Highlights = true the messages mentioning us are copied to &highlights, from all
+// channels and private messages, so they don't get lost in a busy channel or in one we
+// aren't on. Each copy says where it was said and has its context ID to reply to it.
+const highlightsChannel = "&highlights"
+
+// channelMentions are the channel wide mentions, highlighted with highlightchannelmentions.
+var channelMentions = []string{"@channel", "@here", "@all"}
+
+// isHighlight tells if text mentions us: it contains one of our mention keys, our nick,
+// a channel wide mention (with highlightchannelmentions) or matches one of highlightkeywords.
+func (u *User) isHighlight(text string) bool {
+	for _, m := range u.MentionKeys {
+		if m != "" && strings.Contains(text, m) {
+			return true
+		}
+	}
+
+	lower := strings.ToLower(text)
+
+	nicks := []string{u.Nick}
+	if me := u.br.GetMe(); me != nil {
+		nicks = append(nicks, me.Nick)
+	}
+
+	for _, nick := range nicks {
+		if nick != "" && containsWord(lower, strings.ToLower(nick)) {
+			return true
+		}
+	}
+
+	if u.config().GetBool(u.br.Protocol() + ".highlightchannelmentions") {
+		for _, m := range channelMentions {
+			if containsWord(lower, m) {
+				return true
+			}
+		}
+	}
+
+	for _, keyword := range u.config().GetStringSlice(u.br.Protocol() + ".highlightkeywords") {
+		re, err := regexp.Compile("(?i)" + keyword)
+		if err != nil {
+			logger.Errorf("highlightkeywords: %s", err)
+			continue
+		}
+
+		if re.MatchString(text) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// containsWord tells if text contains word, not as a part of a longer word.
+func containsWord(text, word string) bool {
+	for i := 0; i < len(text); {
+		j := strings.Index(text[i:], word)
+		if j < 0 {
+			return false
+		}
+
+		start, end := i+j, i+j+len(word)
+		if (start == 0 || !isWordByte(text[start-1])) && (end == len(text) || !isWordByte(text[end])) {
+			return true
+		}
+
+		i = start + 1
+	}
+
+	return false
+}
+
+// isWordByte tells if b can be part of a nick.
+func isWordByte(b byte) bool {
+	switch {
+	case b >= 'a' && b <= 'z', b >= 'A' && b <= 'Z', b >= '0' && b <= '9':
+		return true
+	case b == '_' || b == '-' || b >= 0x80:
+		return true
+	}
+
+	return false
+}
+
+// highlight copies the message of from to &highlights if it mentions us.
+// channelID is what the context ID of the message is counted in, ts is set when replaying.
+func (u *User) highlight(from, channelID, messageID, parentID, ts, text string) {
+	if !u.config().GetBool(u.br.Protocol()+".highlights") || !u.isHighlight(text) {
+		return
+	}
+
+	prefix := u.prefixContext(channelID, messageID, parentID, "highlight") + " "
+	if ts != "" {
+		prefix = "[" + ts + "] " + prefix
+	}
+
+	ch := u.Srv.Channel(highlightsChannel)
+
+	for _, line := range strings.Split(text, "\n") {
+		if line == "" {
+			continue
+		}
+
+		ch.SpoofMessage(from, prefix+line)
+	}
+}
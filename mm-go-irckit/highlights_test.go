@@ -0,0 +1,60 @@
+package irckit
+
+import (
+	"testing"
+
+	"github.com/42wim/matterircd/bridge"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+type meBridge struct {
+	protocolBridge
+	me *bridge.UserInfo
+}
+
+func (b *meBridge) GetMe() *bridge.UserInfo {
+	return b.me
+}
+
+func TestIsHighlight(t *testing.T) {
+	u := newBridgeUser(nil, nil, viper.New(), nil)
+	u.Nick = "bob"
+	u.MentionKeys = []string{"", "Robert"}
+	u.br = &meBridge{protocolBridge{protocol: "mattermost"}, &bridge.UserInfo{Nick: "bob.smith"}}
+
+	for _, tc := range []struct {
+		text string
+		want bool
+	}{
+		{"hi bob", true},
+		{"@Bob: ping", true},
+		{"bob.", true},
+		{"ask bob.smith", true},
+		{"bobby is here", false},
+		{"kebob", false},
+		{"Robert, lunch?", true},
+		{"robert, lunch?", false},
+		{"@channel deploy", false},
+		{"release is out", false},
+	} {
+		assert.Equal(t, tc.want, u.isHighlight(tc.text), tc.text)
+	}
+
+	u.v.Set("mattermost.highlightchannelmentions", true)
+	u.v.Set("mattermost.highlightkeywords", []string{"release", `\bdeploy(ment)?s?\b`})
+
+	for _, tc := range []struct {
+		text string
+		want bool
+	}{
+		{"@channel deploy", true},
+		{"@here", true},
+		{"all of you", false},
+		{"Release is out", true},
+		{"deployments done", true},
+		{"redeploy", false},
+	} {
+		assert.Equal(t, tc.want, u.isHighlight(tc.text), tc.text)
+	}
+}
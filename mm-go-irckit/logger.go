@@ -20,3 +20,14 @@ func SetLogLevel(level string) {
 func IsDebugLevel() bool {
 	return LogLevel == "debug"
 }
+
+// setDebugLogging switches debug logging on or off while running.
+func setDebugLogging(on bool) {
+	level, name := logrus.InfoLevel, ""
+	if on {
+		level, name = logrus.DebugLevel, "debug"
+	}
+
+	logger.Logger.SetLevel(level)
+	SetLogLevel(name)
+}
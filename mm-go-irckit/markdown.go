@@ -0,0 +1,384 @@
+package irckit
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/alecthomas/chroma/v2/quick"
+	"github.com/muesli/reflow/ansi"
+	"github.com/muesli/reflow/truncate"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	east "github.com/yuin/goldmark/extension/ast"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// IRC formatting control codes.
+const (
+	ircBold          = "\x02"
+	ircItalic        = "\x1d"
+	ircStrikethrough = "\x1e"
+	ircMonospace     = "\x11"
+)
+
+var markdownParser = goldmark.New(
+	goldmark.WithExtensions(extension.Table, extension.Strikethrough),
+).Parser()
+
+// markdownRenderer renders (Mattermost flavoured) markdown to IRC formatted text
+// by walking the markdown AST.
+type markdownRenderer struct {
+	source []byte
+	// width is the wrap width, used to align tables.
+	width int
+	// emphasis enables IRC formatting codes, otherwise the markdown markers are kept.
+	emphasis bool
+	// unicode enables unicode list bullets, rules and emoji.
+	unicode bool
+	// highlight is the chroma "formatter:style" used for code blocks, empty disables highlighting.
+	highlight string
+}
+
+func newMarkdownRenderer(width int, emphasis bool, unicode bool, highlight string) *markdownRenderer {
+	return &markdownRenderer{
+		width:     width,
+		emphasis:  emphasis,
+		unicode:   unicode,
+		highlight: highlight,
+	}
+}
+
+// Render converts msg to IRC formatted lines separated by "\n".
+func (r *markdownRenderer) Render(msg string) string {
+	r.source = []byte(msg)
+	doc := markdownParser.Parse(text.NewReader(r.source))
+
+	return strings.Join(r.renderBlocks(doc), "\n")
+}
+
+func (r *markdownRenderer) renderBlocks(parent ast.Node) []string {
+	var lines []string
+
+	for n := parent.FirstChild(); n != nil; n = n.NextSibling() {
+		lines = append(lines, r.renderBlock(n)...)
+	}
+
+	return lines
+}
+
+//nolint:cyclop
+func (r *markdownRenderer) renderBlock(node ast.Node) []string {
+	switch n := node.(type) {
+	case *ast.Paragraph, *ast.TextBlock:
+		return strings.Split(r.renderInlines(n), "\n")
+	case *ast.Heading:
+		if r.emphasis {
+			return []string{ircBold + r.renderInlines(n) + ircBold}
+		}
+		return []string{strings.Repeat("#", n.Level) + " " + r.renderInlines(n)}
+	case *ast.ThematicBreak:
+		if r.unicode {
+			return []string{strings.Repeat("─", 20)}
+		}
+		return []string{strings.Repeat("-", 20)}
+	case *ast.Blockquote:
+		lines := r.renderBlocks(n)
+		for i, line := range lines {
+			lines[i] = "> " + line
+		}
+		return lines
+	case *ast.List:
+		return r.renderList(n)
+	case *ast.FencedCodeBlock:
+		lang := string(n.Language(r.source))
+		lines := []string{"```" + lang}
+		lines = append(lines, r.renderCode(n, lang)...)
+		return append(lines, "```")
+	case *ast.CodeBlock:
+		return r.renderCode(n, "")
+	case *ast.HTMLBlock:
+		lines := r.rawLines(n)
+		if n.HasClosure() {
+			lines = append(lines, strings.TrimRight(string(n.ClosureLine.Value(r.source)), "\n"))
+		}
+		return lines
+	case *east.Table:
+		return r.renderTable(n)
+	default:
+		return r.renderBlocks(n)
+	}
+}
+
+func (r *markdownRenderer) renderList(list *ast.List) []string {
+	var lines []string
+
+	number := list.Start
+
+	for item := list.FirstChild(); item != nil; item = item.NextSibling() {
+		marker := string(list.Marker) + " "
+
+		switch {
+		case list.IsOrdered():
+			marker = fmt.Sprintf("%d%c ", number, list.Marker)
+			number++
+		case r.unicode:
+			marker = "• "
+		}
+
+		itemLines := r.renderBlocks(item)
+		if len(itemLines) == 0 {
+			itemLines = []string{""}
+		}
+
+		indent := strings.Repeat(" ", ansi.PrintableRuneWidth(marker))
+		for i, line := range itemLines {
+			if i == 0 {
+				lines = append(lines, marker+line)
+				continue
+			}
+			lines = append(lines, indent+line)
+		}
+	}
+
+	return lines
+}
+
+func (r *markdownRenderer) rawLines(n ast.Node) []string {
+	var b strings.Builder
+
+	segments := n.Lines()
+	for i := 0; i < segments.Len(); i++ {
+		segment := segments.At(i)
+		b.Write(segment.Value(r.source))
+	}
+
+	return strings.Split(strings.TrimRight(b.String(), "\n"), "\n")
+}
+
+// renderCode highlights the whole code block at once, so multi-line constructs
+// (comments, strings) are lexed correctly.
+func (r *markdownRenderer) renderCode(n ast.Node, lang string) []string {
+	code := strings.Join(r.rawLines(n), "\n")
+
+	if r.highlight != "" && lang != "" {
+		formatter := "terminal256"
+		style := "pygments"
+		v := strings.SplitN(r.highlight, ":", 2)
+		if len(v) == 2 {
+			formatter = v[0]
+			style = v[1]
+		}
+
+		var b bytes.Buffer
+		if err := quick.Highlight(&b, code, lang, formatter, style); err == nil {
+			code = strings.TrimRight(b.String(), "\n")
+		}
+	}
+
+	lines := strings.Split(code, "\n")
+	for i, line := range lines {
+		// keep empty lines inside code blocks, they would be skipped otherwise.
+		if line == "" {
+			lines[i] = " "
+		}
+	}
+
+	return lines
+}
+
+//nolint:gocognit,cyclop
+func (r *markdownRenderer) renderTable(table *east.Table) []string {
+	var rows [][]string
+
+	for row := table.FirstChild(); row != nil; row = row.NextSibling() {
+		var cells []string
+		for cell := row.FirstChild(); cell != nil; cell = cell.NextSibling() {
+			cells = append(cells, strings.ReplaceAll(r.renderInlines(cell), "\n", " "))
+		}
+		rows = append(rows, cells)
+	}
+
+	columns := len(table.Alignments)
+	widths := make([]int, columns)
+
+	for _, row := range rows {
+		for i, cell := range row {
+			if i < columns && ansi.PrintableRuneWidth(cell) > widths[i] {
+				widths[i] = ansi.PrintableRuneWidth(cell)
+			}
+		}
+	}
+
+	// shrink the widest column until the table fits the wrap width.
+	separator := " | "
+	for r.width > 0 {
+		total := len(separator) * (columns - 1)
+		widest := 0
+		for i, w := range widths {
+			total += w
+			if w > widths[widest] {
+				widest = i
+			}
+		}
+		if total <= r.width || widths[widest] <= 3 {
+			break
+		}
+		widths[widest]--
+	}
+
+	ellipsis := "..."
+	if r.unicode {
+		ellipsis = "…"
+	}
+
+	var lines []string
+
+	for rowIdx, row := range rows {
+		cells := make([]string, columns)
+		for i := range cells {
+			cell := ""
+			if i < len(row) {
+				cell = row[i]
+			}
+			if ansi.PrintableRuneWidth(cell) > widths[i] {
+				cell = truncate.StringWithTail(cell, uint(widths[i]), ellipsis)
+				if r.emphasis {
+					// formatting may have been cut off, reset it.
+					cell += "\x0f"
+				}
+			}
+			cells[i] = alignCell(cell, widths[i], table.Alignments[i])
+		}
+
+		line := strings.TrimRight(strings.Join(cells, separator), " ")
+		if rowIdx == 0 && r.emphasis {
+			line = ircBold + line + ircBold
+		}
+		lines = append(lines, line)
+
+		if rowIdx == 0 {
+			dashes := make([]string, columns)
+			for i, w := range widths {
+				dashes[i] = strings.Repeat("-", w)
+			}
+			lines = append(lines, strings.Join(dashes, "-+-"))
+		}
+	}
+
+	return lines
+}
+
+func alignCell(cell string, width int, alignment east.Alignment) string {
+	pad := width - ansi.PrintableRuneWidth(cell)
+	if pad <= 0 {
+		return cell
+	}
+
+	switch alignment {
+	case east.AlignRight:
+		return strings.Repeat(" ", pad) + cell
+	case east.AlignCenter:
+		return strings.Repeat(" ", pad/2) + cell + strings.Repeat(" ", pad-pad/2)
+	default:
+		return cell + strings.Repeat(" ", pad)
+	}
+}
+
+func (r *markdownRenderer) renderInlines(parent ast.Node) string {
+	var b, texts strings.Builder
+
+	// consecutive text nodes are collected first, shortcodes like :money_mouth_face:
+	// can be split over several nodes.
+	for n := parent.FirstChild(); n != nil; n = n.NextSibling() {
+		if _, ok := n.(*ast.Text); ok {
+			r.renderInline(&texts, n)
+			continue
+		}
+
+		b.WriteString(r.renderText(texts.String()))
+		texts.Reset()
+
+		r.renderInline(&b, n)
+	}
+
+	b.WriteString(r.renderText(texts.String()))
+
+	return b.String()
+}
+
+func (r *markdownRenderer) renderText(text string) string {
+	if r.unicode {
+		return replaceEmojiShortcodes(text)
+	}
+
+	return text
+}
+
+//nolint:cyclop
+func (r *markdownRenderer) renderInline(b *strings.Builder, node ast.Node) {
+	switch n := node.(type) {
+	case *ast.Text:
+		value := n.Segment.Value(r.source)
+		if !n.IsRaw() {
+			value = util.UnescapePunctuations(value)
+			value = util.ResolveNumericReferences(value)
+			value = util.ResolveEntityNames(value)
+		}
+		b.Write(value)
+		// Mattermost shows every newline as a line break.
+		if n.SoftLineBreak() || n.HardLineBreak() {
+			b.WriteString("\n")
+		}
+	case *ast.String:
+		b.Write(n.Value)
+	case *ast.CodeSpan:
+		var code strings.Builder
+		for c := n.FirstChild(); c != nil; c = c.NextSibling() {
+			if t, ok := c.(*ast.Text); ok {
+				code.Write(t.Segment.Value(r.source))
+			}
+		}
+		r.wrapInline(b, code.String(), ircMonospace, "`")
+	case *ast.Emphasis:
+		if n.Level >= 2 {
+			r.wrapInline(b, r.renderInlines(n), ircBold, "**")
+		} else {
+			r.wrapInline(b, r.renderInlines(n), ircItalic, "*")
+		}
+	case *east.Strikethrough:
+		r.wrapInline(b, r.renderInlines(n), ircStrikethrough, "~~")
+	case *ast.Link:
+		r.writeLink(b, r.renderInlines(n), string(n.Destination))
+	case *ast.Image:
+		r.writeLink(b, r.renderInlines(n), string(n.Destination))
+	case *ast.AutoLink:
+		b.Write(n.Label(r.source))
+	case *ast.RawHTML:
+		for i := 0; i < n.Segments.Len(); i++ {
+			segment := n.Segments.At(i)
+			b.Write(segment.Value(r.source))
+		}
+	default:
+		b.WriteString(r.renderInlines(n))
+	}
+}
+
+func (r *markdownRenderer) wrapInline(b *strings.Builder, inner string, code string, marker string) {
+	if !r.emphasis {
+		code = marker
+	}
+
+	b.WriteString(code + inner + code)
+}
+
+func (r *markdownRenderer) writeLink(b *strings.Builder, label string, url string) {
+	if label == "" || label == url {
+		b.WriteString(url)
+		return
+	}
+
+	b.WriteString(label + " <" + url + ">")
+}
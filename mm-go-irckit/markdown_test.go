@@ -0,0 +1,117 @@
+package irckit
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var markdownTests = []struct {
+	Desc     string
+	Value    string
+	Result   string
+	Emphasis bool
+	Width    int
+}{
+	{
+		Desc:     "plain text",
+		Value:    "hello world",
+		Result:   "hello world",
+		Emphasis: true,
+	},
+	{
+		Desc:     "newlines are kept",
+		Value:    "line one\nline two",
+		Result:   "line one\nline two",
+		Emphasis: true,
+	},
+	{
+		Desc:     "emphasis",
+		Value:    "*italic* **bold** ~~strike~~ `code`",
+		Result:   "\x1ditalic\x1d \x02bold\x02 \x1estrike\x1e \x11code\x11",
+		Emphasis: true,
+	},
+	{
+		Desc:     "emphasis disabled",
+		Value:    "*italic* **bold** `code`",
+		Result:   "*italic* **bold** `code`",
+		Emphasis: false,
+	},
+	{
+		Desc:     "intraword underscores",
+		Value:    "snake_case_name",
+		Result:   "snake_case_name",
+		Emphasis: true,
+	},
+	{
+		Desc:     "heading",
+		Value:    "# Title\ntext",
+		Result:   "\x02Title\x02\ntext",
+		Emphasis: true,
+	},
+	{
+		Desc:     "link",
+		Value:    "see [the docs](https://example.com) and <https://example.org>",
+		Result:   "see the docs <https://example.com> and https://example.org",
+		Emphasis: true,
+	},
+	{
+		Desc:     "blockquote",
+		Value:    "> quoted\n> text",
+		Result:   "> quoted\n> text",
+		Emphasis: true,
+	},
+	{
+		Desc:     "nested list",
+		Value:    "- one\n  - two\n- three",
+		Result:   "- one\n  - two\n- three",
+		Emphasis: true,
+	},
+	{
+		Desc:     "ordered list",
+		Value:    "3. three\n4. four",
+		Result:   "3. three\n4. four",
+		Emphasis: true,
+	},
+	{
+		Desc:     "horizontal rule",
+		Value:    "above\n\n---\nbelow",
+		Result:   "above\n--------------------\nbelow",
+		Emphasis: true,
+	},
+	{
+		Desc:     "code block",
+		Value:    "```go\nfunc main() {\n\n}\n```",
+		Result:   "```go\nfunc main() {\n \n}\n```",
+		Emphasis: true,
+	},
+	{
+		Desc:     "table",
+		Value:    "| a | b |\n|---|--:|\n| long cell | 1 |",
+		Result:   "a         | b\n----------+--\nlong cell | 1",
+		Emphasis: false,
+	},
+	{
+		Desc:     "table truncated to width",
+		Value:    "| a | b |\n|---|---|\n| a very long cell | x |",
+		Result:   "a         | b\n----------+--\na very... | x",
+		Emphasis: false,
+		Width:    13,
+	},
+}
+
+func TestMarkdownRender(t *testing.T) {
+	for _, tc := range markdownTests {
+		width := 440
+		if tc.Width != 0 {
+			width = tc.Width
+		}
+		r := newMarkdownRenderer(width, tc.Emphasis, false, "")
+		assert.Equal(t, tc.Result, r.Render(tc.Value), tc.Desc)
+	}
+}
+
+func TestMarkdownRenderEmoji(t *testing.T) {
+	r := newMarkdownRenderer(440, true, true, "")
+	assert.Equal(t, "🤑 \x11:money_mouth_face:\x11", r.Render(":money_mouth_face: `:money_mouth_face:`"))
+}
@@ -0,0 +1,169 @@
+package irckit
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/42wim/matterircd/bridge"
+)
+
+// We know the notification preferences of our channels (mattermost), MutedChannels says what
+// happens with the muted ones:
+//
+//	join     joined like the others (the default)
+//	exclude  not joined, their messages are hidden, mentions still go to &highlights
+//	messages not joined, their messages go to &messages without highlights
+//
+// ShowMentions doesn't mark mentions in channels we don't want notifications of.
+
+const (
+	mutedExclude  = "exclude"
+	mutedMessages = "messages"
+)
+
+// channelNotifier returns the bridge of u if it has notification preferences per channel.
+func (u *User) channelNotifier() (bridge.ChannelNotifier, bool) {
+	notifier, ok := u.br.(bridge.ChannelNotifier)
+
+	return notifier, ok
+}
+
+// loadChannelNotify gets the notification preferences of our channels.
+func (u *User) loadChannelNotify() {
+	notifier, ok := u.channelNotifier()
+	if !ok {
+		return
+	}
+
+	notify, err := notifier.GetChannelNotify()
+	if err != nil {
+		logger.Errorf("getting the channel notification preferences of %s failed: %s", u.Nick, err)
+		return
+	}
+
+	u.notifyMutex.Lock()
+	u.channelNotify = notify
+	u.notifyMutex.Unlock()
+}
+
+// notifyOf returns our notification preferences of channelID, nil if we don't know them.
+func (u *User) notifyOf(channelID string) *bridge.ChannelNotify {
+	u.notifyMutex.RLock()
+	defer u.notifyMutex.RUnlock()
+
+	return u.channelNotify[channelID]
+}
+
+// mutedMode returns what happens with channelID when it's muted (see MutedChannels),
+// "" when it isn't or when muted channels are joined.
+func (u *User) mutedMode(channelID string) string {
+	if notify := u.notifyOf(channelID); notify == nil || !notify.Muted {
+		return ""
+	}
+
+	switch mode := strings.ToLower(u.config().GetString(u.br.Protocol() + ".mutedchannels")); mode {
+	case mutedExclude, mutedMessages:
+		return mode
+	default:
+		return ""
+	}
+}
+
+// showMentionsIn tells if ShowMentions marks the mentions in channelID.
+func (u *User) showMentionsIn(channelID string) bool {
+	if u.mutedMode(channelID) == mutedMessages {
+		return false
+	}
+
+	notify := u.notifyOf(channelID)
+
+	return notify == nil || notify.Desktop != "none"
+}
+
+func (u *User) handleChannelNotifyEvent(event *bridge.ChannelNotifyEvent) {
+	u.notifyMutex.Lock()
+	if u.channelNotify == nil {
+		u.channelNotify = make(map[string]*bridge.ChannelNotify)
+	}
+	u.channelNotify[event.ChannelID] = event.Notify
+	u.notifyMutex.Unlock()
+
+	brchannel, err := u.br.GetChannel(event.ChannelID)
+	if err != nil || brchannel.DM {
+		return
+	}
+
+	u.rejoinChannel(brchannel)
+}
+
+// mutedChannels returns the names of the channels we muted.
+func (u *User) mutedChannels() []string {
+	u.notifyMutex.RLock()
+	defer u.notifyMutex.RUnlock()
+
+	var names []string
+
+	for channelID, notify := range u.channelNotify {
+		if notify.Muted {
+			names = append(names, "#"+u.br.GetChannelName(channelID))
+		}
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+func mute(u *User, toUser *User, args []string, service string) {
+	if len(args) == 0 {
+		names := u.mutedChannels()
+		if len(names) == 0 {
+			u.MsgUser(toUser, "no muted channels")
+			return
+		}
+
+		u.MsgUser(toUser, "muted: "+strings.Join(names, ", "))
+
+		return
+	}
+
+	muteChannel(u, toUser, args[0], true)
+}
+
+func unmute(u *User, toUser *User, args []string, service string) {
+	muteChannel(u, toUser, args[0], false)
+}
+
+func muteChannel(u *User, toUser *User, name string, muted bool) {
+	notifier, ok := u.channelNotifier()
+	if !ok {
+		u.MsgUser(toUser, "muting is not supported on "+u.br.Protocol())
+		return
+	}
+
+	channelID := u.br.GetChannelID(strings.TrimPrefix(name, "#"), u.br.GetMe().TeamID)
+	if !strings.HasPrefix(name, "#") || channelID == "" {
+		u.MsgUser(toUser, "channel "+name+" does not exist")
+		return
+	}
+
+	if err := notifier.MuteChannel(channelID, muted); err != nil {
+		u.MsgUser(toUser, "changing "+name+" failed: "+err.Error())
+		return
+	}
+
+	// the bridge tells us too, but we don't wait for it.
+	notify := bridge.ChannelNotify{}
+	if old := u.notifyOf(channelID); old != nil {
+		notify = *old
+	}
+
+	notify.Muted = muted
+	u.handleChannelNotifyEvent(&bridge.ChannelNotifyEvent{ChannelID: channelID, Notify: &notify})
+
+	if muted {
+		u.MsgUser(toUser, "muted "+name)
+	} else {
+		u.MsgUser(toUser, "unmuted "+name)
+	}
+}
@@ -0,0 +1,38 @@
+package irckit
+
+import (
+	"testing"
+
+	"github.com/42wim/matterircd/bridge"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMutedMode(t *testing.T) {
+	u := newBridgeUser(nil, nil, viper.New(), nil)
+	u.br = &protocolBridge{protocol: "mattermost"}
+	u.channelNotify = map[string]*bridge.ChannelNotify{
+		"muted":  {Muted: true},
+		"quiet":  {Desktop: "none"},
+		"normal": {Desktop: "all"},
+	}
+
+	for _, tc := range []struct {
+		mode         string
+		channelID    string
+		want         string
+		showMentions bool
+	}{
+		{"", "muted", "", true},
+		{"join", "muted", "", true},
+		{"exclude", "muted", mutedExclude, true},
+		{"Messages", "muted", mutedMessages, false},
+		{"messages", "quiet", "", false},
+		{"messages", "normal", "", true},
+		{"messages", "unknown", "", true},
+	} {
+		u.v.Set("mattermost.mutedchannels", tc.mode)
+		assert.Equal(t, tc.want, u.mutedMode(tc.channelID), tc.mode+" "+tc.channelID)
+		assert.Equal(t, tc.showMentions, u.showMentionsIn(tc.channelID), tc.mode+" "+tc.channelID)
+	}
+}
@@ -0,0 +1,282 @@
+package irckit
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/42wim/matterircd/bridge"
+	"github.com/sorcix/irc"
+)
+
+// Networks are extra bridges on the same IRC connection, logged in with "login network=<name> ...".
+// Every network has its own User sharing the connection of the IRC client, their channels and
+// nicks get the name of the network as prefix (#work.town-square and work^john).
+// Commands for those channels and nicks are handled by the User of the network.
+// The separators can't collide: channel and team names have no dots (#team/channel and
+// #channel/t-<id> stay as they are) and bot nicks end with |bot.
+
+const (
+	networkChannelSeparator = "."
+	networkNickSeparator    = "^"
+)
+
+var networkNameRegExp = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// routedCommands are the commands handled by the network of their target.
+var routedCommands = map[string]bool{
+	irc.PRIVMSG: true,
+	irc.NOTICE:  true,
+	irc.JOIN:    true,
+	irc.PART:    true,
+	irc.TOPIC:   true,
+	irc.KICK:    true,
+	irc.INVITE:  true,
+	irc.NAMES:   true,
+	irc.WHO:     true,
+	irc.WHOIS:   true,
+	irc.MODE:    true,
+}
+
+// networkBridge adds the name of the network to the channel names of a bridge.
+type networkBridge struct {
+	bridge.Bridger
+	network string
+}
+
+func (b *networkBridge) addPrefix(name string) string {
+	if !strings.HasPrefix(name, "#") {
+		return name
+	}
+
+	return "#" + b.network + networkChannelSeparator + name[1:]
+}
+
+func (b *networkBridge) stripPrefix(name string) string {
+	if strings.HasPrefix(name, "#"+b.network+networkChannelSeparator) {
+		return "#" + strings.TrimPrefix(name, "#"+b.network+networkChannelSeparator)
+	}
+
+	return strings.TrimPrefix(name, b.network+networkChannelSeparator)
+}
+
+func (b *networkBridge) Join(channelName string) (string, string, error) {
+	return b.Bridger.Join(b.stripPrefix(channelName))
+}
+
+func (b *networkBridge) List() (map[string]string, error) {
+	list, err := b.Bridger.List()
+	if err != nil {
+		return nil, err
+	}
+
+	prefixed := make(map[string]string)
+	for name, topic := range list {
+		prefixed["#"+b.network+networkChannelSeparator+strings.TrimPrefix(name, "#")] = topic
+	}
+
+	return prefixed, nil
+}
+
+func (b *networkBridge) GetChannel(channelID string) (*bridge.ChannelInfo, error) {
+	return b.Bridger.GetChannel(b.stripPrefix(channelID))
+}
+
+func (b *networkBridge) GetChannelName(channelID string) string {
+	return b.addPrefix(b.Bridger.GetChannelName(b.stripPrefix(channelID)))
+}
+
+func (b *networkBridge) GetChannelID(name, teamID string) string {
+	return b.Bridger.GetChannelID(b.stripPrefix(name), teamID)
+}
+
+// networkArg removes the network=<name> argument from args.
+func networkArg(args []string) (string, []string) {
+	rest := []string{}
+	network := ""
+
+	for _, arg := range args {
+		if strings.HasPrefix(strings.ToLower(arg), "network=") {
+			network = arg[len("network="):]
+			continue
+		}
+
+		rest = append(rest, arg)
+	}
+
+	return network, rest
+}
+
+// getNetwork returns the User of network name.
+func (u *User) getNetwork(name string) *User {
+	u.networksMutex.RLock()
+	defer u.networksMutex.RUnlock()
+
+	return u.networks[strings.ToLower(name)]
+}
+
+func (u *User) getNetworks() []*User {
+	u.networksMutex.RLock()
+	defer u.networksMutex.RUnlock()
+
+	networks := make([]*User, 0, len(u.networks))
+	for _, network := range u.networks {
+		networks = append(networks, network)
+	}
+
+	return networks
+}
+
+// addNetwork returns the User of network name, creating it if needed.
+func (u *User) addNetwork(name string) *User {
+	u.networksMutex.Lock()
+	defer u.networksMutex.Unlock()
+
+	name = strings.ToLower(name)
+	if network, ok := u.networks[name]; ok {
+		return network
+	}
+
+	network := newBridgeUser(u.Conn, u.Srv, u.getBaseConfig(), u.lastViewedAtDB)
+	network.Nick = u.Nick
+	network.Real = u.Real
+	network.Host = u.Host
+	network.network = name
+	network.parent = u
+
+	u.networks[name] = network
+
+	return network
+}
+
+func (u *User) removeNetwork(name string) {
+	u.networksMutex.Lock()
+	defer u.networksMutex.Unlock()
+
+	delete(u.networks, strings.ToLower(name))
+
+	for id, network := range u.channelNetworks {
+		if network.network == name {
+			delete(u.channelNetworks, id)
+		}
+	}
+}
+
+// networkOf returns the User of the network target (a channel or nick) belongs to.
+func (u *User) networkOf(target string) *User {
+	var (
+		name string
+		ok   bool
+	)
+
+	if strings.HasPrefix(target, "#") {
+		name, _, ok = strings.Cut(target[1:], networkChannelSeparator)
+	} else {
+		name, _, ok = strings.Cut(target, networkNickSeparator)
+	}
+
+	if !ok {
+		return nil
+	}
+
+	return u.getNetwork(name)
+}
+
+// networkFor returns the User which should handle msg.
+func (u *User) networkFor(msg *irc.Message) *User {
+	if !routedCommands[msg.Command] {
+		return u
+	}
+
+	for _, param := range msg.Params {
+		for _, target := range strings.Split(param, ",") {
+			if network := u.networkOf(target); network != nil {
+				return network
+			}
+		}
+	}
+
+	return u
+}
+
+// claimChannel marks channelID as a channel of the network of u.
+func (u *User) claimChannel(channelID string) {
+	if u.parent == nil || channelID == "" {
+		return
+	}
+
+	u.parent.networksMutex.Lock()
+	u.parent.channelNetworks[channelID] = u
+	u.parent.networksMutex.Unlock()
+}
+
+// channelOwner returns the User of the network channelID (or name) belongs to.
+func (u *User) channelOwner(channelID string) *User {
+	u.networksMutex.RLock()
+	network, ok := u.channelNetworks[channelID]
+	u.networksMutex.RUnlock()
+
+	if ok {
+		return network
+	}
+
+	if network := u.networkOf(channelID); network != nil {
+		return network
+	}
+
+	return u
+}
+
+// joinOwnChannel joins one of our own channels (eg &messages) once for all networks.
+func (u *User) joinOwnChannel(ch Channel) {
+	for _, other := range ch.Users() {
+		if !other.Ghost && other.Nick == u.Nick {
+			return
+		}
+	}
+
+	ch.Join(u) //nolint:errcheck
+}
+
+// renameNetworks gives the Users of all networks the nick of u.
+func (u *User) renameNetworks() {
+	for _, network := range u.getNetworks() {
+		network.Nick = u.Nick
+	}
+}
+
+// logoutNetworks logs out the bridges of all networks.
+func (u *User) logoutNetworks() {
+	for _, network := range u.getNetworks() {
+		if network.br != nil {
+			network.br.Logout()
+		}
+
+		u.removeNetwork(network.network)
+	}
+}
+
+// eventChannelID returns the channel an event of the bridge is about.
+func eventChannelID(event *bridge.Event) string {
+	switch e := event.Data.(type) {
+	case *bridge.ChannelMessageEvent:
+		return e.ChannelID
+	case *bridge.DirectMessageEvent:
+		return e.ChannelID
+	case *bridge.FileEvent:
+		return e.ChannelID
+	case *bridge.ChannelAddEvent:
+		return e.ChannelID
+	case *bridge.ChannelRemoveEvent:
+		return e.ChannelID
+	case *bridge.ChannelCreateEvent:
+		return e.ChannelID
+	case *bridge.ChannelTopicEvent:
+		return e.ChannelID
+	case *bridge.ReactionAddEvent:
+		return e.ChannelID
+	case *bridge.ReactionRemoveEvent:
+		return e.ChannelID
+	}
+
+	return ""
+}
@@ -0,0 +1,89 @@
+package irckit
+
+import (
+	"testing"
+
+	"github.com/42wim/matterircd/bridge"
+	"github.com/sorcix/irc"
+	"github.com/stretchr/testify/assert"
+)
+
+type namesBridge struct {
+	bridge.Bridger
+	ids map[string]string
+}
+
+func (b *namesBridge) GetChannelName(channelID string) string {
+	return "#" + b.ids[channelID]
+}
+
+func (b *namesBridge) GetChannelID(name, teamID string) string {
+	for id, n := range b.ids {
+		if n == name {
+			return id
+		}
+	}
+
+	return ""
+}
+
+func TestNetworkBridge(t *testing.T) {
+	br := &networkBridge{
+		Bridger: &namesBridge{ids: map[string]string{"id1": "town-square", "id2": "team/devops", "id3": "work/devops/t-abc"}},
+		network: "work",
+	}
+
+	assert.Equal(t, "#work.town-square", br.GetChannelName("id1"))
+	assert.Equal(t, "#work.team/devops", br.GetChannelName("id2"))
+	assert.Equal(t, "#work.work/devops/t-abc", br.GetChannelName("id3"), "a team with the name of the network")
+	assert.Equal(t, "id1", br.GetChannelID("work.town-square", ""))
+	assert.Equal(t, "id2", br.GetChannelID("team/devops", ""))
+	assert.Equal(t, "id3", br.GetChannelID("work.work/devops/t-abc", ""))
+}
+
+func TestNetworkFor(t *testing.T) {
+	u := newBridgeUser(nil, NewServer("matterircd"), nil, nil)
+	u.Nick = "me"
+	work := u.addNetwork("Work")
+
+	assert.Equal(t, work, u.getNetwork("work"))
+	assert.Equal(t, "me", work.Nick)
+
+	tests := []struct {
+		msg  *irc.Message
+		want *User
+	}{
+		{&irc.Message{Command: irc.PRIVMSG, Params: []string{"#work.town-square"}}, work},
+		{&irc.Message{Command: irc.PRIVMSG, Params: []string{"work^john"}}, work},
+		{&irc.Message{Command: irc.JOIN, Params: []string{"#devops,#work.devops"}}, work},
+		{&irc.Message{Command: irc.KICK, Params: []string{"#work.devops", "work^john"}}, work},
+		{&irc.Message{Command: irc.PRIVMSG, Params: []string{"work|bot"}}, u},
+		{&irc.Message{Command: irc.PRIVMSG, Params: []string{"#work/devops"}}, u},
+		{&irc.Message{Command: irc.JOIN, Params: []string{"#work/devops/t-abc"}}, u},
+		{&irc.Message{Command: irc.PRIVMSG, Params: []string{"#town-square"}}, u},
+		{&irc.Message{Command: irc.PRIVMSG, Params: []string{"#home/town-square"}}, u},
+		{&irc.Message{Command: irc.NICK, Params: []string{"work^me"}}, u},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, u.networkFor(tt.msg), tt.msg.String())
+	}
+
+	work.claimChannel("id1")
+	assert.Equal(t, work, u.channelOwner("id1"))
+	assert.Equal(t, u, u.channelOwner("id2"))
+
+	u.removeNetwork("work")
+	assert.Nil(t, u.getNetwork("work"))
+	assert.Equal(t, u, u.channelOwner("id1"))
+}
+
+func TestNetworkArg(t *testing.T) {
+	network, args := networkArg([]string{"network=work", "server", "user", "pass"})
+	assert.Equal(t, "work", network)
+	assert.Equal(t, []string{"server", "user", "pass"}, args)
+
+	network, args = networkArg([]string{"user", "pass"})
+	assert.Equal(t, "", network)
+	assert.Equal(t, []string{"user", "pass"}, args)
+}
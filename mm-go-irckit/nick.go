@@ -0,0 +1,117 @@
+package irckit
+
+import (
+	"strings"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// nicksBucket is the bucket (in the bucket of the logged in user) storing the nicks
+// given to colliding users, so they don't change between sessions.
+var nicksBucket = []byte("nicks")
+
+// nickSuffix returns the suffix added to a nick of userID which collides with another user.
+func nickSuffix(userID string) string {
+	id := strings.ToLower(userID)
+	if len(id) > 4 {
+		id = id[len(id)-4:]
+	}
+
+	return "_" + id
+}
+
+// resolveNick returns the IRC nick for the account userID wanting nick.
+// If another account already uses that nick, a stable suffix based on userID is added.
+// taken can be used to check nicks which aren't added to the server yet.
+func (u *User) resolveNick(userID, nick string, taken map[string]string) string {
+	if u.network != "" {
+		nick = u.network + networkNickSeparator + nick
+	}
+
+	nick = sanitizeNick(nick)
+
+	owner := func(candidate string) (string, bool) {
+		if id, ok := taken[strings.ToLower(candidate)]; ok {
+			return id, true
+		}
+		if other, ok := u.Srv.HasNick(candidate); ok {
+			return other.User, true
+		}
+		return "", false
+	}
+
+	candidate := nick
+	if persisted := u.loadNick(userID, nick); persisted != "" {
+		candidate = persisted
+	}
+
+	ownerID, ok := owner(candidate)
+	if !ok || strings.EqualFold(ownerID, userID) || candidate != nick {
+		return candidate
+	}
+
+	suffixed := nick + nickSuffix(userID)
+
+	// the current owner keeps the nick, also in the next sessions.
+	if u.loadNick(ownerID, nick) == "" {
+		u.saveNick(ownerID, nick, nick)
+	}
+	u.saveNick(userID, nick, suffixed)
+
+	logger.Debugf("nick %s of %s collides with %s, using %s", nick, userID, ownerID, suffixed)
+
+	return suffixed
+}
+
+func nickKey(userID, nick string) []byte {
+	return []byte(strings.ToLower(userID) + "/" + nick)
+}
+
+func (u *User) loadNick(userID, nick string) string {
+	if u.lastViewedAtDB == nil || u.User == "" {
+		return ""
+	}
+
+	var persisted string
+
+	err := u.lastViewedAtDB.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(u.User))
+		if b == nil {
+			return nil
+		}
+
+		if nb := b.Bucket(nicksBucket); nb != nil {
+			persisted = string(nb.Get(nickKey(userID, nick)))
+		}
+
+		return nil
+	})
+	if err != nil {
+		logger.Errorf("loading nick of %s failed: %s", userID, err)
+	}
+
+	return persisted
+}
+
+func (u *User) saveNick(userID, nick, persisted string) {
+	if u.lastViewedAtDB == nil || u.User == "" {
+		return
+	}
+
+	err := u.lastViewedAtDB.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(u.User))
+		if err != nil {
+			return err
+		}
+
+		nb, err := b.CreateBucketIfNotExists(nicksBucket)
+		if err != nil {
+			return err
+		}
+
+		return nb.Put(nickKey(userID, nick), []byte(persisted))
+	})
+	if err != nil {
+		logger.Errorf("saving nick of %s failed: %s", userID, err)
+	}
+}
@@ -0,0 +1,70 @@
+package irckit
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/42wim/matterircd/bridge"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	bolt "go.etcd.io/bbolt"
+)
+
+// newTestDB returns a database in a temporary directory, closed at the end of the test.
+func newTestDB(t *testing.T) *bolt.DB {
+	t.Helper()
+
+	SetLogger(logrus.NewEntry(logrus.New()))
+
+	db, err := bolt.Open(filepath.Join(t.TempDir(), "test.db"), 0o600, nil)
+	assert.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	return db
+}
+
+func TestResolveNick(t *testing.T) {
+	db := newTestDB(t)
+
+	newSession := func() *User {
+		u := NewUser(nil)
+		u.User = "me"
+		u.Srv = NewServer("matterircd")
+		u.lastViewedAtDB = db
+		return u
+	}
+
+	u := newSession()
+	users := u.CreateUsersFromInfo([]*bridge.UserInfo{
+		{Nick: "john.doe", User: "aaaaaaaaaaaaaaaaaaaaaaaaaa"},
+		{Nick: "john.doe", User: "bbbbbbbbbbbbbbbbbbbbbbbbbb"},
+		{Nick: "John.Doe", User: "cccccccccccccccccccccccccc"},
+	})
+	assert.Equal(t, "john.doe", users[0].Nick)
+	assert.Equal(t, "john.doe_bbbb", users[1].Nick)
+	assert.Equal(t, "John.Doe_cccc", users[2].Nick)
+
+	// the nicks don't flip when the users show up in a different order.
+	u = newSession()
+	assert.Equal(t, "john.doe_bbbb", u.createUserFromInfo(&bridge.UserInfo{Nick: "john.doe", User: "bbbbbbbbbbbbbbbbbbbbbbbbbb"}).Nick)
+	assert.Equal(t, "john.doe", u.createUserFromInfo(&bridge.UserInfo{Nick: "john.doe", User: "aaaaaaaaaaaaaaaaaaaaaaaaaa"}).Nick)
+
+	// nicks differing in case only collide too.
+	assert.Equal(t, "JOHN.DOE_ffff", u.createUserFromInfo(&bridge.UserInfo{Nick: "JOHN.DOE", User: "ffffffffffffffffffffffffff"}).Nick)
+
+	// another account using the nick of a real user.
+	assert.Equal(t, "john.doe_dddd", u.ghostNick(&bridge.UserInfo{Nick: "john.doe", User: "dddddddddddddddddddddddddd"}))
+
+	// bots get their own user, without changing the cached info.
+	u.v = viper.New()
+	u.br = &protocolBridge{protocol: "mattermost"}
+	info := &bridge.UserInfo{Nick: "john.doe", User: "aaaaaaaaaaaaaaaaaaaaaaaaaa/john.doe", Bot: true}
+	bot := u.createUserFromInfo(info)
+	assert.Equal(t, "john.doe|bot", bot.Nick)
+	assert.Equal(t, "bot", bot.Host)
+	assert.Equal(t, "john.doe", info.Nick)
+
+	u.v.Set("mattermost.botsuffix", "")
+	assert.Equal(t, "john.doe_eeee", u.ghostNick(&bridge.UserInfo{Nick: "john.doe", User: "eeeeeeeeeeeeeeeeeeeeeeeeee", Bot: true}))
+}
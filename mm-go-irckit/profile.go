@@ -0,0 +1,125 @@
+package irckit
+
+import (
+	"strings"
+
+	"github.com/42wim/matterircd/bridge"
+	"github.com/spf13/viper"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Profiles are [users.<nick>] sections in the config. A user connecting with the nick of a
+// profile has to send its password with PASS and is then logged in with the credentials of
+// the profile. The other settings of a profile override the global ones, eg
+//
+//	[users.john]
+//	Password = "$2a$10$..."
+//	Protocol = "mattermost"
+//	Server = "chat.example.com"
+//	Team = "myteam"
+//	Token = "yourpersonaltoken"
+//
+//	[users.john.mattermost]
+//	JoinExclude = ["#town-square"]
+
+// profileKeys are the settings of a profile which aren't overrides.
+var profileKeys = map[string]bool{
+	"password": true,
+	"protocol": true,
+	"server":   true,
+	"team":     true,
+	"login":    true,
+	"token":    true,
+}
+
+// profileFor returns the profile of nick, nil if there's none.
+func profileFor(v *viper.Viper, nick string) *viper.Viper {
+	if v == nil || nick == "" || strings.Contains(nick, ".") {
+		return nil
+	}
+
+	return v.Sub("users." + strings.ToLower(nick))
+}
+
+// checkProfilePassword checks the PASS of a user against the bcrypt hash of profile p.
+// Profiles without a password don't allow logins.
+func checkProfilePassword(p *viper.Viper, pass []string) bool {
+	if len(pass) == 0 {
+		return false
+	}
+
+	return checkPasswordHash(p.GetString("password"), strings.Join(pass, " "))
+}
+
+// checkPasswordHash checks pass against a bcrypt hash from the config.
+func checkPasswordHash(hash, pass string) bool {
+	if hash == "" {
+		return false
+	}
+
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(pass)) == nil
+}
+
+// profileConfig returns a copy of config v with the overrides of profile p.
+func profileConfig(v *viper.Viper, p *viper.Viper) *viper.Viper {
+	settings := v.AllSettings()
+	delete(settings, "users")
+
+	pv := viper.New()
+	pv.MergeConfigMap(settings) //nolint:errcheck
+
+	for key, value := range p.AllSettings() {
+		if !profileKeys[key] {
+			pv.MergeConfigMap(map[string]interface{}{key: value}) //nolint:errcheck
+		}
+	}
+
+	return pv
+}
+
+// profileProtocol returns the protocol of profile p, mattermost by default.
+func profileProtocol(p *viper.Viper) string {
+	if protocol := p.GetString("protocol"); protocol != "" {
+		return strings.ToLower(protocol)
+	}
+
+	return "mattermost"
+}
+
+// loginProfile logs u in with the credentials of profile p.
+func loginProfile(u *User, toUser *User, p *viper.Viper) {
+	protocol := profileProtocol(p)
+
+	switch protocol {
+	case "mattermost":
+		u.Credentials = bridge.Credentials{
+			Server: p.GetString("server"),
+			Team:   p.GetString("team"),
+			Login:  p.GetString("login"),
+			Pass:   "token=" + p.GetString("token"),
+		}
+
+		if !u.isValidServer(u.Credentials.Server, protocol) {
+			u.MsgUser(toUser, "not allowed to connect to "+u.Credentials.Server)
+			return
+		}
+	case "slack":
+		u.Credentials = bridge.Credentials{
+			Team:  p.GetString("team"),
+			Token: p.GetString("token"),
+		}
+	default:
+		u.MsgUser(toUser, "unknown protocol "+protocol+" in profile")
+		return
+	}
+
+	u.inprogress = true
+	defer func() { u.inprogress = false }()
+
+	if err := u.loginTo(protocol); err != nil {
+		u.MsgUser(toUser, err.Error())
+		return
+	}
+
+	u.MsgUser(toUser, "login OK")
+}
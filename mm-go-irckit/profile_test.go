@@ -0,0 +1,53 @@
+package irckit
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestProfile(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	assert.NoError(t, err)
+
+	v := viper.New()
+	v.SetConfigType("toml")
+	assert.NoError(t, v.ReadConfig(strings.NewReader(`
+Bouncer = false
+
+[mattermost]
+PrefixContext = false
+JoinExclude = ["#random"]
+
+[users.john]
+Password = "`+string(hash)+`"
+Server = "chat.example.com"
+Token = "token"
+Bouncer = true
+
+[users.john.mattermost]
+PrefixContext = true
+`)))
+
+	assert.Nil(t, profileFor(v, "jane"))
+	assert.Nil(t, profileFor(v, "john.doe"))
+
+	p := profileFor(v, "John")
+	assert.NotNil(t, p)
+	assert.Equal(t, "mattermost", profileProtocol(p))
+
+	assert.True(t, checkProfilePassword(p, []string{"secret"}))
+	assert.False(t, checkProfilePassword(p, []string{"wrong"}))
+	assert.False(t, checkProfilePassword(p, nil))
+
+	pv := profileConfig(v, p)
+	assert.True(t, pv.GetBool("bouncer"))
+	assert.True(t, pv.GetBool("mattermost.prefixcontext"))
+	assert.Equal(t, []string{"#random"}, pv.GetStringSlice("mattermost.joinexclude"))
+	assert.False(t, pv.IsSet("token"))
+	assert.False(t, pv.IsSet("users"))
+	assert.False(t, v.GetBool("mattermost.prefixcontext"), "the global config is unchanged")
+}
@@ -0,0 +1,167 @@
+package irckit
+
+import (
+	"time"
+
+	"github.com/42wim/matterircd/bridge"
+	"github.com/sorcix/irc"
+)
+
+// The bridges tell us when they lose their connection and when it's back (see bridge.Supervisor).
+// After a reconnect we get the channels, members, topics and posts we missed in the meantime.
+// Slack can't give us the posts (GetPostsSince returns nothing), those stay missed.
+
+const (
+	// maxSeenPosts is the number of post IDs remembered to not show posts twice.
+	maxSeenPosts = 1000
+	// resyncMargin is subtracted from the time of the last event when fetching posts,
+	// to not miss any because of clock differences.
+	resyncMargin = time.Minute
+)
+
+// markSeen remembers postID as shown, it returns false when it was already.
+func (u *User) markSeen(postID string) bool {
+	if postID == "" {
+		return true
+	}
+
+	u.seenPostsMutex.Lock()
+	defer u.seenPostsMutex.Unlock()
+
+	if _, ok := u.seenPosts[postID]; ok {
+		return false
+	}
+
+	u.seenPosts[postID] = struct{}{}
+	u.seenPostsOrder = append(u.seenPostsOrder, postID)
+
+	if len(u.seenPostsOrder) > maxSeenPosts {
+		delete(u.seenPosts, u.seenPostsOrder[0])
+		u.seenPostsOrder = u.seenPostsOrder[1:]
+	}
+
+	return true
+}
+
+// postReceived is called for every post the bridge sends us.
+func (u *User) postReceived(postID string) {
+	u.markSeen(postID)
+	u.lastEventAt = time.Now()
+}
+
+// serviceNotice sends text as NOTICE from the service of our bridge.
+func (u *User) serviceNotice(text string) {
+	if u.network != "" {
+		text = "[" + u.network + "] " + text
+	}
+
+	svc, ok := u.Srv.HasUser(u.br.Protocol())
+	if !ok {
+		return
+	}
+
+	u.Encode(&irc.Message{
+		Prefix:   svc.Prefix(),
+		Command:  irc.NOTICE,
+		Params:   []string{u.Nick},
+		Trailing: text,
+	})
+}
+
+func (u *User) handleDisconnectEvent() {
+	logger.Infof("connection of %s to %s lost", u.Nick, u.br.Protocol())
+	u.serviceNotice("connection to " + u.br.Protocol() + " lost, reconnecting")
+}
+
+func (u *User) handleReconnectEvent() {
+	logger.Infof("connection of %s to %s restored, resyncing", u.Nick, u.br.Protocol())
+	u.serviceNotice("reconnected to " + u.br.Protocol() + ", getting what we missed")
+
+	u.resync(u.lastEventAt.Add(-resyncMargin))
+}
+
+// resync brings our channels up to date with the bridge, posts since the given time are replayed.
+func (u *User) resync(since time.Time) {
+	known := make(map[string]bool)
+	for _, brchannel := range u.br.GetChannels() {
+		known[brchannel.ID] = true
+	}
+
+	if err := u.br.UpdateChannels(); err != nil {
+		logger.Errorf("updating channels after reconnect failed: %s", err)
+	}
+
+	u.loadChannelNotify()
+	u.loadCategories()
+	u.loadFollowed()
+
+	current := make(map[string]bool)
+
+	for _, brchannel := range u.br.GetChannels() {
+		current[brchannel.ID] = true
+		u.claimChannel(brchannel.ID)
+
+		if brchannel.DM && !u.config().GetBool(u.br.Protocol()+".joindm") {
+			continue
+		}
+
+		spoof := u.createSpoof(brchannel)
+
+		// a channel we got added to.
+		if !known[brchannel.ID] {
+			if from, logSince := u.replaySince(brchannel); from != 0 {
+				u.replayPosts(brchannel, spoof, from, logSince)
+			}
+
+			continue
+		}
+
+		if !brchannel.DM {
+			u.resyncChannel(brchannel)
+		}
+
+		u.replayPosts(brchannel, spoof, since.UnixNano()/int64(time.Millisecond), "reconnect")
+	}
+
+	// channels we left or got removed from.
+	for id := range known {
+		if current[id] {
+			continue
+		}
+
+		if ch, ok := u.Srv.HasChannel(id); ok && ch.HasUser(u) {
+			ch.Part(u, "")
+		}
+	}
+}
+
+// resyncChannel updates the members and the topic of brchannel.
+func (u *User) resyncChannel(brchannel *bridge.ChannelInfo) {
+	ch, ok := u.Srv.HasChannel(brchannel.ID)
+	if !ok || !ch.HasUser(u) {
+		return
+	}
+
+	users, err := u.br.GetChannelUsers(brchannel.ID)
+	if err != nil {
+		logger.Errorf("getting members of %s after reconnect failed: %s", brchannel.Name, err)
+		return
+	}
+
+	members := make(map[string]bool)
+	for _, user := range users {
+		members[user.User] = true
+	}
+
+	for _, other := range ch.Users() {
+		if other.Ghost && !other.Bot && !members[other.User] {
+			ch.Part(other, "")
+		}
+	}
+
+	if topic := u.br.Topic(brchannel.ID); topic != ch.GetTopic() {
+		if svc, ok := u.Srv.HasUser(u.br.Protocol()); ok {
+			ch.Topic(svc, topic)
+		}
+	}
+}
@@ -0,0 +1,23 @@
+package irckit
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMarkSeen(t *testing.T) {
+	u := newBridgeUser(nil, NewServer("matterircd"), nil, nil)
+
+	assert.True(t, u.markSeen("post1"))
+	assert.False(t, u.markSeen("post1"), "posts are shown once")
+	assert.True(t, u.markSeen(""))
+
+	for i := 0; i < maxSeenPosts; i++ {
+		u.markSeen(fmt.Sprintf("other%d", i))
+	}
+
+	assert.Len(t, u.seenPosts, maxSeenPosts)
+	assert.True(t, u.markSeen("post1"), "the oldest posts are forgotten")
+}
@@ -0,0 +1,229 @@
+package irckit
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/42wim/matterircd/bridge"
+	"github.com/spf13/viper"
+)
+
+// ReloadConfig applies the reloaded config v to all sessions. Their config is rebuilt
+// (with their profile and settings on top), channels they may not join anymore are parted,
+// channels they may join now are joined and they get a notice of what changed.
+func ReloadConfig(v *viper.Viper) {
+	for _, u := range sessions.getRunning() {
+		u.reloadConfig(v)
+	}
+}
+
+func (u *User) reloadConfig(v *viper.Viper) {
+	base := v
+
+	if u.profile != "" {
+		if p := profileFor(v, u.profile); p != nil {
+			base = profileConfig(v, p)
+		} else {
+			logger.Infof("profile of %s is gone, using the global config", u.profile)
+		}
+	}
+
+	for _, user := range append([]*User{u}, u.getNetworks()...) {
+		user.applyConfig(base)
+	}
+}
+
+// applyConfig makes base the config of u.
+func (u *User) applyConfig(base *viper.Viper) {
+	// not logged in, we don't have our own copy yet.
+	if u.br == nil {
+		u.setBaseConfig(base)
+		return
+	}
+
+	before := u.settingValues()
+
+	// start from the config again, our settings and the channels we joined by hand stay.
+	u.rebaseConfig(base, func(c *configChange) {
+		for key := range settingKinds {
+			c.Set(u.br.Protocol()+"."+key, nil)
+		}
+
+		u.applySettings(c)
+
+		for _, channel := range u.joinedByHand() {
+			u.applyAllowJoin(c, channel)
+		}
+	})
+
+	if u.br.Protocol() == "mattermost" && !u.isValidServer(u.Credentials.Server, u.br.Protocol()) {
+		u.serviceNotice("configuration reloaded, " + u.Credentials.Server + " isn't allowed anymore, logging out")
+		u.logoutBridge()
+
+		return
+	}
+
+	var changes []string
+
+	for key, value := range u.settingValues() {
+		if before[key] != value {
+			changes = append(changes, key)
+		}
+	}
+
+	sort.Strings(changes)
+
+	joined, parted := u.rejoinChannels()
+
+	if len(changes) == 0 && len(joined) == 0 && len(parted) == 0 {
+		return
+	}
+
+	text := "configuration reloaded"
+	if len(changes) > 0 {
+		text += ", changed: " + strings.Join(changes, ", ")
+	}
+
+	if len(joined) > 0 {
+		text += ", joined: " + strings.Join(joined, ", ")
+	}
+
+	if len(parted) > 0 {
+		text += ", parted: " + strings.Join(parted, ", ")
+	}
+
+	u.serviceNotice(text)
+}
+
+// settingValues returns the values of the settings users can change.
+func (u *User) settingValues() map[string]string {
+	values := make(map[string]string, len(settingKinds))
+	for key := range settingKinds {
+		values[key] = u.settingValue(key)
+	}
+
+	return values
+}
+
+// rejoinChannels joins the channels we may join now and parts the ones we may not anymore,
+// our optional and virtual channels included.
+func (u *User) rejoinChannels() ([]string, []string) {
+	var joined, parted []string
+
+	for _, brchannel := range u.br.GetChannels() {
+		if brchannel.DM {
+			continue
+		}
+
+		name, join := u.rejoinChannel(brchannel)
+
+		switch {
+		case name != "" && join:
+			joined = append(joined, name)
+		case name != "":
+			parted = append(parted, name)
+		}
+	}
+
+	vjoined, vparted := u.syncVirtualChannels()
+	joined = append(joined, vjoined...)
+	parted = append(parted, vparted...)
+
+	// networks share the &highlights and &threads of their parent.
+	if u.parent != nil {
+		return joined, parted
+	}
+
+	for _, oc := range optionalChannels {
+		ch, exists := u.Srv.HasChannel(oc.name)
+		on := exists && ch.HasUser(u)
+
+		switch enabled := u.config().GetBool(u.br.Protocol() + "." + oc.setting); {
+		case enabled && !on:
+			u.joinOwnChannel(u.Srv.Channel(oc.name))
+			joined = append(joined, oc.name)
+		case !enabled && on:
+			ch.Part(u, "")
+			parted = append(parted, oc.name)
+		}
+	}
+
+	return joined, parted
+}
+
+// allowJoin lets channel, joined by hand, be joined although the join settings leave it out,
+// until it's parted. It's kept across config reloads.
+func (u *User) allowJoin(channel string) {
+	u.joinedMutex.Lock()
+	u.joinedChannels[channel] = true
+	u.joinedMutex.Unlock()
+
+	u.updateConfig(func(c *configChange) {
+		u.applyAllowJoin(c, channel)
+	})
+}
+
+// applyAllowJoin removes channel from joinexclude of c and adds it to joininclude (if used).
+func (u *User) applyAllowJoin(c *configChange, channel string) {
+	c.Set(u.br.Protocol()+".joinexclude", removeStringInSlice(channel, c.GetStringSlice(u.br.Protocol()+".joinexclude")))
+
+	if channels := c.GetStringSlice(u.br.Protocol() + ".joininclude"); len(channels) > 0 {
+		c.Set(u.br.Protocol()+".joininclude", append(channels, channel))
+	}
+}
+
+// forgetJoin forgets that channel was joined by hand, it's parted.
+func (u *User) forgetJoin(channel string) {
+	u.joinedMutex.Lock()
+	delete(u.joinedChannels, channel)
+	u.joinedMutex.Unlock()
+
+	u.updateConfig(func(c *configChange) {
+		c.Set(u.br.Protocol()+".joininclude", removeStringInSlice(channel, c.GetStringSlice(u.br.Protocol()+".joininclude")))
+	})
+}
+
+// joinedByHand returns the channels joined by hand.
+func (u *User) joinedByHand() []string {
+	u.joinedMutex.Lock()
+	defer u.joinedMutex.Unlock()
+
+	channels := make([]string, 0, len(u.joinedChannels))
+	for channel := range u.joinedChannels {
+		channels = append(channels, channel)
+	}
+
+	sort.Strings(channels)
+
+	return channels
+}
+
+// rejoinChannel joins brchannel if we may join it now or parts it if we may not anymore,
+// it returns its name and whether it was joined, "" if nothing changed.
+func (u *User) rejoinChannel(brchannel *bridge.ChannelInfo) (string, bool) {
+	ch, exists := u.Srv.HasChannel(brchannel.ID)
+	on := exists && ch.HasUser(u)
+	mayJoin := u.mayJoin(brchannel.ID)
+
+	switch {
+	case mayJoin && !on:
+		u.syncChannel(brchannel.ID, u.br.GetChannelName(brchannel.ID))
+		return u.Srv.Channel(brchannel.ID).String(), true
+	case !mayJoin && on:
+		ch.Part(u, "")
+		return ch.String(), false
+	}
+
+	return "", false
+}
+
+// logoutBridge logs u out of its bridge and ends its session.
+func (u *User) logoutBridge() {
+	u.stopSession()
+	u.br.Logout()
+	u.logoutFrom(u.br.Protocol())
+
+	if u.parent != nil {
+		u.parent.removeNetwork(u.network)
+	}
+}
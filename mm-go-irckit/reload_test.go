@@ -0,0 +1,67 @@
+package irckit
+
+import (
+	"testing"
+
+	"github.com/42wim/matterircd/bridge"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+type channelsBridge struct {
+	protocolBridge
+	v *viper.Viper
+}
+
+func (b *channelsBridge) UpdateConfig(v *viper.Viper) {
+	b.v = v
+}
+
+func (b *channelsBridge) GetChannels() []*bridge.ChannelInfo {
+	return nil
+}
+
+func TestApplyConfig(t *testing.T) {
+	db := newTestDB(t)
+
+	global := viper.New()
+	global.Set("mattermost.prefixcontext", true)
+	global.Set("mattermost.hidereplies", false)
+
+	u := newTestUser(global, db)
+	br := &channelsBridge{protocolBridge: protocolBridge{protocol: "mattermost"}}
+	u.br = br
+	notLoggedIn := newBridgeUser(nil, NewServer("matterircd"), global, db)
+
+	assert.NoError(t, u.setSetting("suffixcontext", true))
+	u.updateConfig(func(c *configChange) {
+		c.Set("mattermost.joininclude", []string{"#joined"})
+	})
+	u.allowJoin("#byhand")
+
+	// the config is replaced, not changed while the bridge reads it.
+	cfg := u.config()
+
+	reloaded := viper.New()
+	reloaded.Set("mattermost.prefixcontext", false)
+	reloaded.Set("mattermost.hidereplies", true)
+	reloaded.Set("mattermost.joinexclude", []string{"#byhand", "#boring"})
+
+	u.applyConfig(reloaded)
+	notLoggedIn.applyConfig(reloaded)
+
+	assert.Same(t, u.config(), br.v, "the bridge gets the new config")
+	assert.True(t, cfg.GetBool("mattermost.prefixcontext"))
+	assert.Equal(t, []string{"#joined", "#byhand"}, cfg.GetStringSlice("mattermost.joininclude"))
+	assert.False(t, u.v.GetBool("mattermost.prefixcontext"))
+	assert.True(t, u.v.GetBool("mattermost.hidereplies"))
+	assert.True(t, u.v.GetBool("mattermost.suffixcontext"), "settings stay")
+	assert.Empty(t, u.v.GetStringSlice("mattermost.joininclude"), "changes while running are dropped")
+	assert.Equal(t, []string{"#boring"}, u.v.GetStringSlice("mattermost.joinexclude"), "channels joined by hand stay")
+
+	u.forgetJoin("#byhand")
+	u.applyConfig(reloaded)
+
+	assert.Equal(t, []string{"#byhand", "#boring"}, u.v.GetStringSlice("mattermost.joinexclude"))
+	assert.Same(t, reloaded, notLoggedIn.config())
+}
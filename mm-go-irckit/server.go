@@ -45,6 +45,9 @@ type Server interface {
 	// HasUser returns an existing User with a given Nick.
 	HasUser(string) (*User, bool)
 
+	// HasNick returns an existing User with a given Nick, regardless of case.
+	HasNick(string) (*User, bool)
+
 	// HasUserID returns an existing User with a given ID
 	HasUserID(string) (*User, bool)
 
@@ -55,6 +58,10 @@ type Server interface {
 	// Channel gets or creates a new channel with the given name and Id.
 	Channel(string) Channel
 
+	// NamedChannel gets or creates a channel with the given Id and name, for channels
+	// the bridge doesn't know about (eg threads).
+	NamedChannel(string, string) Channel
+
 	// HasChannel returns an existing Channel with a given name.
 	HasChannel(string) (Channel, bool)
 
@@ -176,6 +183,18 @@ func (s *server) HasUser(nick string) (*User, bool) {
 	return nil, false
 }
 
+// HasNick returns the user using nick in any case, these nicks collide on IRC.
+func (s *server) HasNick(nick string) (*User, bool) {
+	s.RLock()
+	defer s.RUnlock()
+	for _, u := range s.users {
+		if strings.EqualFold(u.Nick, nick) {
+			return u, true
+		}
+	}
+	return nil, false
+}
+
 func (s *server) HasUserID(userID string) (*User, bool) {
 	s.RLock()
 	u, exists := s.users[strings.ToLower(userID)]
@@ -227,10 +246,11 @@ func (s *server) Channel(channelID string) Channel {
 	s.Lock()
 	ch, ok := s.channels[channelID]
 	if !ok {
-		service := s.u.br.Protocol()
-		name := s.u.br.GetChannelName(channelID)
+		owner := s.u.channelOwner(channelID)
+		service := owner.br.Protocol()
+		name := owner.br.GetChannelName(channelID)
 
-		info, err := s.u.br.GetChannel(channelID)
+		info, err := owner.br.GetChannel(channelID)
 		if err != nil {
 			// don't error on our special channels
 			if channelID != "" && !strings.HasPrefix(channelID, "&") && channelID != s.u.Nick && channelID != s.u.Username {
@@ -256,6 +276,30 @@ func (s *server) Channel(channelID string) Channel {
 	return ch
 }
 
+// NamedChannel returns an existing or new channel with the given ID and name.
+func (s *server) NamedChannel(channelID string, name string) Channel {
+	s.Lock()
+	defer s.Unlock()
+
+	if ch, ok := s.channels[channelID]; ok {
+		return ch
+	}
+
+	service := ""
+	if s.u != nil && s.u.br != nil {
+		service = s.u.br.Protocol()
+	}
+
+	ch := s.config.NewChannel(s, channelID, name, service, make(map[string]bool))
+
+	logger.Debugf("new channel id: %s, name: %s", channelID, name)
+
+	s.channels[channelID] = ch
+	s.channels[name] = ch
+
+	return ch
+}
+
 // UnlinkChannel unlinks the channel from the server's storage, returns whether it existed.
 func (s *server) UnlinkChannel(ch Channel) {
 	s.Lock()
@@ -286,7 +330,13 @@ func (s *server) Quit(u *User, message string) {
 	delete(s.users, u.ID())
 	s.Unlock()
 
-	u.br.Logout()
+	u.stopSession()
+	u.logoutNetworks()
+
+	// u.br is nil when the user quits before logging in.
+	if u.br != nil {
+		u.br.Logout()
+	}
 }
 
 // Len returns the number of users connected to the server.
@@ -349,15 +399,24 @@ func (s *server) Handle(u *User) {
 }
 
 func (s *server) handle(u *User) {
-	var partMsg string
+	var (
+		partMsg string
+		running sync.WaitGroup
+	)
+	sessions.track(u)
+	defer sessions.untrack(u)
 	defer s.Quit(u, partMsg)
+	// let the commands finish before quitting, eg the last (flushed) messages.
+	defer running.Wait()
 	for msg := range u.DecodeCh {
 		if msg == nil {
 			// Ignore empty messages
 			continue
 		}
+		running.Add(1)
 		go func(msg *irc.Message) {
-			err := s.commands.Run(s, u, msg)
+			defer running.Done()
+			err := s.commands.Run(s, u.networkFor(msg), msg)
 			logger.Debugf("Executed %#v %#v", msg, err)
 			if err == ErrUnknownCommand {
 				// TODO: Emit event?
@@ -403,7 +462,7 @@ func (s *server) handshake(u *User) error {
 	u.Host = u.ResolveHost()
 	go u.Decode()
 
-	timeout := u.v.GetInt("HandshakeTimeout")
+	timeout := u.config().GetInt("HandshakeTimeout")
 	if timeout == 0 {
 		timeout = 10
 	}
@@ -413,7 +472,10 @@ func (s *server) handshake(u *User) error {
 outerloop:
 	for {
 		select {
-		case msg := <-u.DecodeCh:
+		case msg, ok := <-u.DecodeCh:
+			if !ok {
+				return ErrHandshakeFailed
+			}
 			// fmt.Printf("in handshake %#v\n", msg)
 			i--
 			// Consume N messages then give up.
@@ -444,21 +506,6 @@ outerloop:
 				u.Pass = msg.Params
 			case irc.JOIN:
 				s.EncodeMessage(u, irc.ERR_NOTREGISTERED, []string{"*"}, "Please register first")
-			// https://ircv3.net/specs/extensions/capability-negotiation.html
-			case irc.CAP:
-				subcommand := msg.Params[0]
-				switch subcommand {
-				case irc.CAP_LS, irc.CAP_LIST:
-					params := fmt.Sprintf("* %s :", subcommand)
-					s.EncodeMessage(u, irc.CAP, []string{params}, "") //nolint:errcheck
-				case irc.CAP_END, irc.CAP_REQ:
-					// Do nothing.
-				default:
-					params := fmt.Sprintf("* %s", subcommand)
-					// github.com/sorcix/irc doesn't yet support ERR_INVALIDCAPCMD (410)
-					s.EncodeMessage(u, "410", []string{params}, "Invalid or unsupported CAP command") //nolint:errcheck
-				}
-				continue
 			}
 
 			if u.Nick == "" || u.User == "" {
@@ -469,7 +516,18 @@ outerloop:
 				u.Nick = u.Nick[:s.config.MaxNickLen]
 			}
 
-			ok := s.add(u)
+			profile := profileFor(u.config(), u.Nick)
+			if profile != nil {
+				if !checkProfilePassword(profile, u.Pass) {
+					s.EncodeMessage(u, irc.ERR_PASSWDMISMATCH, []string{u.Nick}, "Password incorrect")
+					return ErrHandshakeFailed
+				}
+
+				u.setBaseConfig(profileConfig(u.config(), profile))
+				u.profile = strings.ToLower(u.Nick)
+			}
+
+			ok = s.add(u)
 			if !ok {
 				s.EncodeMessage(u, irc.ERR_NICKNAMEINUSE, []string{u.Nick}, "Nickname is already in use")
 				continue
@@ -482,7 +540,10 @@ outerloop:
 				if len(u.Pass) == 1 {
 					service = "slack"
 				}
-				login(u, &User{
+				if profile != nil {
+					service = profileProtocol(profile)
+				}
+				toUser := &User{
 					UserInfo: &bridge.UserInfo{
 						Nick: service,
 						User: service,
@@ -490,9 +551,12 @@ outerloop:
 						Host: "service",
 					},
 					channels: map[Channel]struct{}{},
-				},
-					u.Pass,
-					service)
+				}
+				if profile != nil {
+					loginProfile(u, toUser, profile)
+				} else {
+					login(u, toUser, u.Pass, service)
+				}
 			}
 
 			return err
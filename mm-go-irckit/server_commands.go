@@ -23,6 +23,7 @@ func DefaultCommands() Commands {
 	cmds.Add(Handler{Command: irc.MOTD, Call: CmdMotd})
 	cmds.Add(Handler{Command: irc.NAMES, Call: CmdNames, MinParams: 1, LoggedIn: true})
 	cmds.Add(Handler{Command: irc.NICK, Call: CmdNick, MinParams: 1})
+	cmds.Add(Handler{Command: irc.OPER, Call: CmdOper, MinParams: 1})
 	cmds.Add(Handler{Command: irc.PART, Call: CmdPart, MinParams: 1, LoggedIn: true})
 	cmds.Add(Handler{Command: irc.PING, Call: CmdPing})
 	cmds.Add(Handler{Command: irc.PRIVMSG, Call: CmdPrivMsg, MinParams: 1})
@@ -119,6 +120,19 @@ func CmdJoin(s Server, u *User, msg *irc.Message) error {
 			continue
 		}
 
+		if channelName == highlightsChannel || channelName == threadsChannel || u.isVirtualChannel(channelName) {
+			u.joinOwnChannel(u.Srv.Channel(channelName))
+			continue
+		}
+
+		if postID := threadPostID(channel); postID != "" && u.br.Protocol() == "mattermost" && !u.isChannelName(channel) {
+			if err := u.joinThread(postID); err != nil {
+				s.EncodeMessage(u, irc.ERR_NOSUCHCHANNEL, []string{u.Nick, channel}, err.Error())
+			}
+
+			continue
+		}
+
 		channelID, _, err := u.br.Join(channelName)
 		if err != nil {
 			logger.Errorf("Cannot join channel %s, id %s, err: %v", channelName, channelID, err)
@@ -130,17 +144,7 @@ func CmdJoin(s Server, u *User, msg *irc.Message) error {
 
 		sync = u.syncChannel
 
-		/*u.v.GetStringSlice(u.br.Protocol()+".joinexclude")
-		u.v.Set(key string, value interface{})
-		*/
-		// if we joined, remove channel from exclude and add to include
-		u.v.Set(u.br.Protocol()+".joinexclude", removeStringInSlice(channel, u.v.GetStringSlice(u.br.Protocol()+".joinexclude")))
-
-		if len(u.v.GetStringSlice(u.br.Protocol()+".joininclude")) > 0 {
-			channels := u.v.GetStringSlice(u.br.Protocol() + ".joininclude")
-			channels = append(channels, channel)
-			u.v.Set(u.br.Protocol()+".joininclude", channels)
-		}
+		u.allowJoin(channel)
 
 		ch := s.Channel(channelID)
 		sync(channelID, channelName)
@@ -276,6 +280,7 @@ func CmdNames(s Server, u *User, msg *irc.Message) error {
 func CmdNick(s Server, u *User, msg *irc.Message) error {
 	if u.br == nil {
 		s.RenameUser(u, msg.Params[0])
+		u.renameNetworks()
 		return nil
 	}
 	// only update mattermost nick if we're logged in
@@ -286,9 +291,36 @@ func CmdNick(s Server, u *User, msg *irc.Message) error {
 	}
 
 	s.RenameUser(u, msg.Params[0])
+	u.renameNetworks()
 	return nil
 }
 
+// CmdOper is a handler for the /OPER command.
+func CmdOper(s Server, u *User, msg *irc.Message) error {
+	params := msg.Params
+	if msg.Trailing != "" {
+		params = append(params, msg.Trailing)
+	}
+
+	if len(params) < 2 {
+		return s.EncodeMessage(u, irc.ERR_NEEDMOREPARAMS, []string{u.Nick, irc.OPER}, "Not enough parameters")
+	}
+
+	if !u.config().IsSet("opers") {
+		return s.EncodeMessage(u, irc.ERR_NOOPERHOST, []string{u.Nick}, "No O-lines for your host")
+	}
+
+	oper := operFor(u.config(), params[0])
+	if oper == nil || !checkPasswordHash(oper.GetString("password"), params[1]) {
+		logger.Infof("OPER as %s by %s failed", params[0], u.Nick)
+		return s.EncodeMessage(u, irc.ERR_PASSWDMISMATCH, []string{u.Nick}, "Password incorrect")
+	}
+
+	u.becomeOper(strings.ToLower(params[0]))
+
+	return s.EncodeMessage(u, irc.RPL_YOUREOPER, []string{u.Nick}, "You are now an IRC operator")
+}
+
 // CmdPart is a handler for the /PART command.
 func CmdPart(s Server, u *User, msg *irc.Message) error {
 	var err error
@@ -305,10 +337,16 @@ func CmdPart(s Server, u *User, msg *irc.Message) error {
 			err = s.EncodeMessage(u, irc.ERR_NOSUCHCHANNEL, []string{chName}, "No such channel")
 			continue
 		}
+
+		if rootID, _, ok := u.threadOf(ch); ok {
+			u.partThread(rootID, ch, msg.Trailing)
+			continue
+		}
+
 		// first part on irc
 		ch.Part(u, msg.Trailing)
 		// now part on mattermost/slack
-		if !u.v.GetBool(u.br.Protocol() + ".PartFake") {
+		if !u.config().GetBool(u.br.Protocol() + ".PartFake") {
 			err = u.br.Part(ch.ID())
 			if err != nil {
 				return err
@@ -317,10 +355,9 @@ func CmdPart(s Server, u *User, msg *irc.Message) error {
 		// part all other (ghost)users on the channel
 		for _, k := range ch.Users() {
 			ch.Part(k, "")
-			// if we parted, remove channel from include
-			u.v.Set(u.br.Protocol()+".joininclude",
-				removeStringInSlice(chName, u.v.GetStringSlice(u.br.Protocol()+".joininclude")))
 		}
+
+		u.forgetJoin(chName)
 	}
 
 	u.br.UpdateChannels()
@@ -374,14 +411,40 @@ func CmdPrivMsg(s Server, u *User, msg *irc.Message) error {
 
 	// are we sending to a channel
 	if ch, exists := s.HasChannel(query); exists {
-		if ch.ID() == "&messages" || ch.ID() == "&users" {
+		if ch.ID() == adminChannel {
+			go u.handleAdmin(adminChannel, msg.Trailing)
 			return nil
 		}
 
+		if ch.ID() == "&messages" || ch.ID() == "&users" || ch.ID() == highlightsChannel || ch.ID() == u.config().GetString(u.br.Protocol()+".botchannel") {
+			return nil
+		}
+
+		msg.Trailing = u.emojiShortcodes(msg.Trailing)
+
 		if parseReactionToMsg(u, msg, ch.ID()) {
 			return nil
 		}
 
+		msg.Trailing = translateMentions(u, msg.Trailing)
+
+		if rootID, tc, ok := u.threadOf(ch); ok {
+			if parseModifyMsg(u, msg, ch.ID()) {
+				return nil
+			}
+
+			return u.msgThread(rootID, tc, msg)
+		}
+
+		// virtual channels have no channel to send to, only replies are possible.
+		if u.isVirtualChannel(ch.ID()) || ch.ID() == threadsChannel {
+			if !u.msgVirtual(ch, msg) && !parseModifyMsg(u, msg, ch.ID()) {
+				u.MsgSpoofUser(u, u.br.Protocol(), "msg: "+msg.Trailing+" could not be sent, reply to a message in "+ch.String()+" with @@<id>")
+			}
+
+			return nil
+		}
+
 		if threadMsgChannel(u, msg, ch.ID()) {
 			return nil
 		}
@@ -401,7 +464,7 @@ func CmdPrivMsg(s Server, u *User, msg *irc.Message) error {
 		u.msgLast[ch.ID()] = [2]string{msgID, ""}
 		u.saveLastViewedAt(ch.ID())
 
-		if u.v.GetBool(u.br.Protocol()+".prefixcontext") || u.v.GetBool(u.br.Protocol()+".suffixcontext") {
+		if u.config().GetBool(u.br.Protocol()+".prefixcontext") || u.config().GetBool(u.br.Protocol()+".suffixcontext") {
 			u.prefixContext(ch.ID(), msgID, "", "posted_self")
 		}
 
@@ -414,6 +477,10 @@ func CmdPrivMsg(s Server, u *User, msg *irc.Message) error {
 		case query == "mattermost" || query == "slack" || query == "mastodon": //nolint:goconst
 			go u.handleServiceBot(query, toUser, msg.Trailing)
 			msg.Trailing = "<redacted>"
+		case isAdminService(toUser):
+			go u.handleAdmin(u.Nick, msg.Trailing)
+		case toUser.isIntegration():
+			err = s.EncodeMessage(u, irc.ERR_CANNOTSENDTOCHAN, msg.Params, "Cannot send to an integration")
 		case toUser.Ghost, toUser.Me:
 			logger.Tracef("sending message %s to user %s", msg.Trailing, toUser.User)
 			// no messages when we're not logged in
@@ -422,11 +489,15 @@ func CmdPrivMsg(s Server, u *User, msg *irc.Message) error {
 				return nil
 			}
 
+			msg.Trailing = u.emojiShortcodes(msg.Trailing)
+
 			if parseReactionToMsg(u, msg, toUser.User) {
 				logger.Trace("matched parseReactionToMsg")
 				return nil
 			}
 
+			msg.Trailing = translateMentions(u, msg.Trailing)
+
 			if threadMsgUser(u, msg, toUser.User) {
 				logger.Trace("matched threadMsgUser")
 				return nil
@@ -446,7 +517,7 @@ func CmdPrivMsg(s Server, u *User, msg *irc.Message) error {
 			u.msgLast[toUser.User] = [2]string{msgID, ""}
 			u.saveLastViewedAt(toUser.User)
 
-			if u.v.GetBool(u.br.Protocol()+".prefixcontext") || u.v.GetBool(u.br.Protocol()+".suffixcontext") {
+			if u.config().GetBool(u.br.Protocol()+".prefixcontext") || u.config().GetBool(u.br.Protocol()+".suffixcontext") {
 				u.prefixContext(toUser.User, msgID, "", "posted_self")
 			}
 
@@ -578,6 +649,84 @@ func parseModifyMsg(u *User, msg *irc.Message, channelID string) bool {
 	return true
 }
 
+var (
+	leadingMentionRegExp = regexp.MustCompile(`^(\\?)([^\s:,]+)([:,])(\s|$)`)
+	threadPrefixRegExp   = regexp.MustCompile(`^\@\@(?:!!|[0-9a-f]{3}|[0-9a-z]{26})\s`)
+)
+
+// translateMentions replaces the IRC nicks of ghost users with a mention the bridge understands,
+// for a leading "nick:" or "nick," and for inline "@nick", other words are left alone.
+// Prefixing a nick with a backslash (eg \nick:) suppresses the translation.
+//
+//nolint:cyclop
+func translateMentions(u *User, text string) string {
+	if !u.config().GetBool(u.br.Protocol() + ".translatementions") {
+		return text
+	}
+
+	// keep the thread/reply prefix as is.
+	prefix := threadPrefixRegExp.FindString(text)
+	text = strings.TrimPrefix(text, prefix)
+
+	lookup := func(nick string) (string, bool) {
+		ghost, ok := u.Srv.HasUser(nick)
+		if !ok || !ghost.Ghost {
+			return "", false
+		}
+
+		switch u.br.Protocol() {
+		case "mattermost":
+			return "@" + ghost.Username, true
+		case "slack":
+			return "<@" + ghost.User + ">", true
+		}
+
+		return "", false
+	}
+
+	// the leading nick has already been handled.
+	skipFirst := false
+
+	if m := leadingMentionRegExp.FindStringSubmatch(text); m != nil {
+		switch mention, ok := lookup(strings.TrimPrefix(m[2], "@")); {
+		case m[1] != "" && ok:
+			text = strings.TrimPrefix(text, m[1])
+			skipFirst = true
+		case ok:
+			text = mention + strings.TrimPrefix(text, m[2])
+			skipFirst = true
+		}
+	}
+
+	// don't touch code, parts between backticks are skipped.
+	parts := strings.Split(text, "`")
+	for i := 0; i < len(parts); i += 2 {
+		words := strings.Split(parts[i], " ")
+		for j, word := range words {
+			if i == 0 && j == 0 && skipFirst {
+				continue
+			}
+
+			nick := strings.TrimRight(word, ".,:;!?)")
+			trail := strings.TrimPrefix(word, nick)
+
+			switch {
+			case strings.HasPrefix(nick, "\\") && len(nick) > 1:
+				if _, ok := lookup(strings.TrimPrefix(strings.TrimPrefix(nick, "\\"), "@")); ok {
+					words[j] = strings.TrimPrefix(word, "\\")
+				}
+			case strings.HasPrefix(nick, "@"):
+				if mention, ok := lookup(strings.TrimPrefix(nick, "@")); ok {
+					words[j] = mention + trail
+				}
+			}
+		}
+		parts[i] = strings.Join(words, " ")
+	}
+
+	return prefix + strings.Join(parts, "`")
+}
+
 var parseThreadIDRegExp = regexp.MustCompile(`(?s)^\@\@(?:(!!|[0-9a-f]{3}|[0-9a-z]{26})\s)(.*)`)
 
 func parseThreadID(u *User, msg *irc.Message, channelID string) (string, string) {
@@ -644,12 +793,14 @@ func threadMsgChannelUser(u *User, msg *irc.Message, channelID string, toUser bo
 		return false
 	}
 
+	u.threadReplied(threadID)
+
 	u.msgLastMutex.Lock()
 	defer u.msgLastMutex.Unlock()
 	u.msgLast[channelID] = [2]string{msgID, threadID}
 	u.saveLastViewedAt(channelID)
 
-	if u.v.GetBool(u.br.Protocol()+".prefixcontext") || u.v.GetBool(u.br.Protocol()+".suffixcontext") {
+	if u.config().GetBool(u.br.Protocol()+".prefixcontext") || u.config().GetBool(u.br.Protocol()+".suffixcontext") {
 		u.prefixContext(channelID, msgID, threadID, "posted_self")
 	}
 
@@ -765,6 +916,12 @@ func CmdWho(s Server, u *User, msg *irc.Message) error {
 	return u.Encode(r...)
 }
 
+// Not defined in the irc package.
+const (
+	rplWhoisAccount = "330"
+	rplWhoisBot     = "335"
+)
+
 // CmdWhois is a handler for the /WHOIS command.
 func CmdWhois(s Server, u *User, msg *irc.Message) error {
 	who := msg.Params[0]
@@ -778,6 +935,16 @@ func CmdWhois(s Server, u *User, msg *irc.Message) error {
 			Trailing: other.Real,
 		})
 
+		// the nick can differ from the account (eg collisions or nicknames).
+		if other.Ghost && other.Username != "" {
+			r = append(r, &irc.Message{
+				Prefix:   s.Prefix(),
+				Params:   []string{u.Nick, other.Nick, other.Username},
+				Command:  rplWhoisAccount,
+				Trailing: "is logged in as",
+			})
+		}
+
 		var chlist string
 		for _, ch := range other.Channels() {
 			chlist += ch.String() + " "
@@ -790,7 +957,19 @@ func CmdWhois(s Server, u *User, msg *irc.Message) error {
 			Trailing: chlist,
 		})
 
-		status, _ := u.br.StatusUser(other.User)
+		if other.Bot {
+			r = append(r, &irc.Message{
+				Prefix:   s.Prefix(),
+				Params:   []string{u.Nick, other.Nick},
+				Command:  rplWhoisBot,
+				Trailing: "is a bot",
+			})
+		}
+
+		status := "online"
+		if !other.isIntegration() {
+			status, _ = u.br.StatusUser(other.User)
+		}
 
 		if status != "online" {
 			r = append(r, &irc.Message{
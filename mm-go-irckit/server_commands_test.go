@@ -0,0 +1,91 @@
+package irckit
+
+import (
+	"testing"
+
+	"github.com/42wim/matterircd/bridge"
+	"github.com/sorcix/irc"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+type protocolBridge struct {
+	bridge.Bridger
+	protocol string
+}
+
+func (b *protocolBridge) Protocol() string {
+	return b.protocol
+}
+
+func TestTranslateMentions(t *testing.T) {
+	srv := NewServer("matterircd")
+
+	alice := NewUser(nil)
+	alice.UserInfo = &bridge.UserInfo{Nick: "Alice_Smith", User: "U123", Username: "alice", Ghost: true}
+	bob := NewUser(nil)
+	bob.UserInfo = &bridge.UserInfo{Nick: "bob", User: "U456", Username: "bobby", Ghost: true}
+	srv.Add(alice)
+	srv.Add(bob)
+
+	v := viper.New()
+	v.Set("mattermost.translatementions", true)
+	v.Set("slack.translatementions", true)
+
+	u := NewUser(nil)
+	u.v = v
+	u.Srv = srv
+	u.br = &protocolBridge{protocol: "mattermost"}
+
+	tests := []struct {
+		Desc   string
+		Value  string
+		Result string
+	}{
+		{"leading colon", "Alice_Smith: hi", "@alice: hi"},
+		{"leading comma", "bob, hi", "@bobby, hi"},
+		{"leading escaped", `\Alice_Smith: hi`, "Alice_Smith: hi"},
+		{"inline at", "ping @bob.", "ping @bobby."},
+		{"inline words", "ask Alice_Smith or bob", "ask Alice_Smith or bob"},
+		{"inline escaped", `ask \@Alice_Smith`, "ask @Alice_Smith"},
+		{"code", "`Alice_Smith: x` @bob", "`Alice_Smith: x` @bobby"},
+		{"thread reply", "@@abc Alice_Smith: hi", "@@abc @alice: hi"},
+		{"unknown", "carol: hi", "carol: hi"},
+	}
+
+	for _, tc := range tests {
+		assert.Equal(t, tc.Result, translateMentions(u, tc.Value), tc.Desc)
+	}
+
+	u.br = &protocolBridge{protocol: "slack"}
+	assert.Equal(t, "<@U123>: hi", translateMentions(u, "Alice_Smith: hi"))
+
+	v.Set("slack.translatementions", false)
+	assert.Equal(t, "Alice_Smith: hi", translateMentions(u, "Alice_Smith: hi"))
+
+}
+
+func TestIntegrationGhost(t *testing.T) {
+	c, client := newTestClient()
+	srv := NewServer("matterircd")
+	u := newBridgeUser(newSessionConn(c), srv, viper.New(), nil)
+	u.Nick, u.User = "me", "me"
+	u.br = &protocolBridge{protocol: "mattermost"}
+
+	hook := NewUser(nil)
+	hook.UserInfo = &bridge.UserInfo{Nick: "jenkins", User: "U123/jenkins", Real: "jenkins", Host: "host", Ghost: true, Bot: true}
+	srv.Add(hook)
+
+	assert.True(t, hook.isIntegration())
+	assert.False(t, u.isIntegration())
+
+	// the bridge has no StatusUser, it would panic when asked about the integration.
+	go CmdWhois(srv, u, &irc.Message{Command: irc.WHOIS, Params: []string{"jenkins"}}) //nolint:errcheck
+	client.expect(t, ":matterircd 311 me jenkins U123/jenkins host * :jenkins")
+	client.expect(t, ":matterircd 319 me jenkins")
+	client.expect(t, ":matterircd 335 me jenkins :is a bot")
+	client.expect(t, ":matterircd 318 me jenkins :End of /WHOIS list.")
+
+	go CmdPrivMsg(srv, u, &irc.Message{Command: irc.PRIVMSG, Params: []string{"jenkins"}, Trailing: "hi"}) //nolint:errcheck
+	client.expect(t, ":matterircd 404 jenkins :Cannot send to an integration")
+}
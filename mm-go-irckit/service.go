@@ -23,6 +23,40 @@ type Command struct {
 	minParams int
 	maxParams int
 	login     bool
+
+	// args describes the arguments, protocolArgs the ones differing per protocol.
+	args         string
+	protocolArgs map[string]string
+	description  string
+	// protocols are the services supporting the command, all of them when empty.
+	protocols []string
+}
+
+// supports tells if cmd can be used on service.
+func (cmd Command) supports(service string) bool {
+	if len(cmd.protocols) == 0 {
+		return true
+	}
+
+	for _, protocol := range cmd.protocols {
+		if protocol == service {
+			return true
+		}
+	}
+
+	return false
+}
+
+// commandUsage returns how command name is used on service, eg "PART #<channel>".
+func commandUsage(name string, service string) string {
+	cmd := cmds[name]
+
+	args := cmd.args
+	if protocolArgs, ok := cmd.protocolArgs[service]; ok {
+		args = protocolArgs
+	}
+
+	return strings.TrimSpace(strings.ToUpper(name) + " " + args)
 }
 
 func logout(u *User, toUser *User, args []string, service string) {
@@ -30,8 +64,20 @@ func logout(u *User, toUser *User, args []string, service string) {
 		u.MsgUser(toUser, "login or logout in progress. Please wait")
 		return
 	}
-	u.br.Logout()
-	u.logoutFrom(u.br.Protocol())
+
+	if len(args) == 1 {
+		if !strings.EqualFold(args[0], "revoke") {
+			u.MsgUser(toUser, "need LOGOUT or LOGOUT revoke")
+			return
+		}
+
+		// end the session on the server too, the next login needs the password (and MFA token) again.
+		if err := u.revokeSession(); err != nil {
+			u.MsgUser(toUser, "revoking session failed: "+err.Error())
+		}
+	}
+
+	u.logoutBridge()
 }
 
 func login(u *User, toUser *User, args []string, service string) {
@@ -119,13 +165,13 @@ func login(u *User, toUser *User, args []string, service string) {
 		datalen = 5
 	}
 
-	if u.v.GetString("mattermost.DefaultTeam") != "" {
-		cred.Team = u.v.GetString("mattermost.DefaultTeam")
+	if u.config().GetString("mattermost.DefaultTeam") != "" {
+		cred.Team = u.config().GetString("mattermost.DefaultTeam")
 		datalen--
 	}
 
-	if u.v.GetString("mattermost.DefaultServer") != "" {
-		cred.Server = u.v.GetString("mattermost.DefaultServer")
+	if u.config().GetString("mattermost.DefaultServer") != "" {
+		cred.Server = u.config().GetString("mattermost.DefaultServer")
 		datalen--
 	}
 
@@ -211,11 +257,6 @@ func login(u *User, toUser *User, args []string, service string) {
 
 //nolint:cyclop
 func search(u *User, toUser *User, args []string, service string) {
-	if service == "slack" {
-		u.MsgUser(toUser, "not implemented")
-		return
-	}
-
 	list := u.br.SearchPosts(strings.Join(args, " "))
 
 	if list == nil || list.(*model.PostList) == nil || len(list.(*model.PostList).Order) == 0 {
@@ -235,8 +276,9 @@ func search(u *User, toUser *User, args []string, service string) {
 
 		nick := u.br.GetUser(postlist.Posts[postlist.Order[i]].UserId).Nick
 
-		u.MsgUser(toUser, "#"+channelname+" <"+nick+"> "+timestamp)
-		u.MsgUser(toUser, strings.Repeat("=", len("#"+channelname+" <"+nick+"> "+timestamp)))
+		header := channelname + " <" + nick + "> " + timestamp
+		u.MsgUser(toUser, header)
+		u.MsgUser(toUser, strings.Repeat("=", len(header)))
 
 		for _, post := range strings.Split(postlist.Posts[postlist.Order[i]].Message, "\n") {
 			if post != "" {
@@ -256,11 +298,6 @@ func search(u *User, toUser *User, args []string, service string) {
 }
 
 func searchUsers(u *User, toUser *User, args []string, service string) {
-	if service == "slack" {
-		u.MsgUser(toUser, "not implemented")
-		return
-	}
-
 	users, err := u.br.SearchUsers(strings.Join(args, " "))
 	if err != nil {
 		u.MsgUser(toUser, fmt.Sprint("Error", err.Error()))
@@ -287,12 +324,6 @@ func getMattermostChannelName(u *User, channelID string) string {
 }
 
 func part(u *User, toUser *User, args []string, service string) {
-	if len(args) != 1 {
-		u.MsgUser(toUser, "need PART #<channel>")
-		u.MsgUser(toUser, "e.g. PART #bugs")
-		return
-	}
-
 	channelName := strings.TrimPrefix(args[0], "#")
 	channelTeamID := u.br.GetMe().TeamID
 	if len(args) == 2 {
@@ -306,21 +337,26 @@ func part(u *User, toUser *User, args []string, service string) {
 	}
 }
 
+// dmChannelID returns the ID of our DM channel with other, "" if there's none.
+func (u *User) dmChannelID(other *User) string {
+	// We need to sort the two user IDs to construct the DM
+	// channel name.
+	userIDs := []string{u.User, other.User}
+	sort.Strings(userIDs)
+	channelName := userIDs[0] + "__" + userIDs[1]
+
+	return u.br.GetChannelID(channelName, u.br.GetMe().TeamID)
+}
+
 //nolint:funlen,gocognit,gocyclo,cyclop
 func scrollback(u *User, toUser *User, args []string, service string) {
-	if service == "slack" {
-		u.MsgUser(toUser, "not implemented")
-		return
-	}
-
 	var err error
 	limit := 0
-	err = nil
 	if len(args) == 2 {
 		limit, err = strconv.Atoi(args[1])
 	}
-	if len(args) == 0 || len(args) > 2 || err != nil {
-		u.MsgUser(toUser, "need SCROLLBACK (#<channel>|<user>|<post/thread ID>) <lines>")
+	if err != nil {
+		u.MsgUser(toUser, "need "+commandUsage("scrollback", service))
 		u.MsgUser(toUser, "e.g. SCROLLBACK #bugs 10 (show last 10 lines from #bugs)")
 		return
 	}
@@ -331,7 +367,7 @@ func scrollback(u *User, toUser *User, args []string, service string) {
 	scrollbackUser, exists := u.Srv.HasUser(search)
 
 	proto := "https"
-	if u.v.GetBool(u.br.Protocol() + ".insecure") {
+	if u.config().GetBool(u.br.Protocol() + ".insecure") {
 		proto = "http"
 	}
 	postlistURL := proto + "://" + u.Credentials.Server + "/" + u.Credentials.Team + "/pl/"
@@ -341,12 +377,7 @@ func scrollback(u *User, toUser *User, args []string, service string) {
 		channelName := strings.ReplaceAll(search, "#", "")
 		channelID = u.br.GetChannelID(channelName, u.br.GetMe().TeamID)
 	case exists && scrollbackUser.Ghost:
-		// We need to sort the two user IDs to construct the DM
-		// channel name.
-		userIDs := []string{u.User, scrollbackUser.User}
-		sort.Strings(userIDs)
-		channelName := userIDs[0] + "__" + userIDs[1]
-		channelID = u.br.GetChannelID(channelName, u.br.GetMe().TeamID)
+		channelID = u.dmChannelID(scrollbackUser)
 	case len(search) == 26:
 		searchPostID = search
 	case strings.HasPrefix(search, "@@"):
@@ -354,7 +385,7 @@ func scrollback(u *User, toUser *User, args []string, service string) {
 	case strings.HasPrefix(strings.ToLower(search), postlistURL):
 		searchPostID = strings.TrimPrefix(search, postlistURL)
 	default:
-		u.MsgUser(toUser, "need SCROLLBACK (#<channel>|<user>|<post/thread ID>) <lines>")
+		u.MsgUser(toUser, "need "+commandUsage("scrollback", service))
 		u.MsgUser(toUser, "e.g. SCROLLBACK #bugs 10 (show last 10 lines from #bugs)")
 		return
 	}
@@ -436,7 +467,7 @@ func scrollback(u *User, toUser *User, args []string, service string) {
 		}
 	}
 
-	if !u.v.GetBool(u.br.Protocol() + ".collapsescrollback") {
+	if !u.config().GetBool(u.br.Protocol() + ".collapsescrollback") {
 		u.MsgUser(toUser, fmt.Sprintf("scrollback results shown in %s", search))
 	}
 }
@@ -445,24 +476,24 @@ func formatScrollbackMsg(u *User, channelID string, channel string, user *User,
 	ts := time.Unix(0, p.CreateAt*int64(time.Millisecond))
 
 	switch {
-	case (u.v.GetBool(u.br.Protocol()+".collapsescrollback") && strings.HasPrefix(channel, "#")):
+	case (u.config().GetBool(u.br.Protocol()+".collapsescrollback") && strings.HasPrefix(channel, "#")):
 		threadMsgID := u.prefixContext(channelID, p.Id, p.RootId, "scrollback")
 		msg := u.formatContextMessage(ts.Format("2006-01-02 15:04"), threadMsgID, msgText)
 		nick += "/" + channel
 		u.Srv.Channel("&messages").SpoofMessage(nick, msg)
-	case u.v.GetBool(u.br.Protocol() + ".collapsescrollback"):
+	case u.config().GetBool(u.br.Protocol() + ".collapsescrollback"):
 		threadMsgID := u.prefixContext(channelID, p.Id, p.RootId, "scrollback")
 		msg := u.formatContextMessage(ts.Format("2006-01-02 15:04"), threadMsgID, msgText)
 		nick += "/" + channel
 		u.Srv.Channel("&messages").SpoofMessage(nick, msg)
-	case (u.v.GetBool(u.br.Protocol()+".prefixcontext") || u.v.GetBool(u.br.Protocol()+".suffixcontext")) && strings.HasPrefix(channel, "#") && nick != systemUser:
+	case (u.config().GetBool(u.br.Protocol()+".prefixcontext") || u.config().GetBool(u.br.Protocol()+".suffixcontext")) && strings.HasPrefix(channel, "#") && nick != systemUser:
 		threadMsgID := u.prefixContext(channelID, p.Id, p.RootId, "scrollback")
 		msg := u.formatContextMessage(ts.Format("2006-01-02 15:04"), threadMsgID, msgText)
 		u.Srv.Channel(channelID).SpoofMessage(nick, msg)
 	case strings.HasPrefix(channel, "#"):
 		msg := "[" + ts.Format("2006-01-02 15:04") + "] " + msgText
 		u.Srv.Channel(channelID).SpoofMessage(nick, msg)
-	case u.v.GetBool(u.br.Protocol()+".prefixcontext") || u.v.GetBool(u.br.Protocol()+".suffixcontext"):
+	case u.config().GetBool(u.br.Protocol()+".prefixcontext") || u.config().GetBool(u.br.Protocol()+".suffixcontext"):
 		threadMsgID := u.prefixContext(channelID, p.Id, p.RootId, "scrollback")
 		msg := u.formatContextMessage(ts.Format("2006-01-02 15:04"), threadMsgID, msgText)
 		u.MsgSpoofUser(user, nick, msg)
@@ -473,19 +504,8 @@ func formatScrollbackMsg(u *User, channelID string, channel string, user *User,
 }
 
 func updatelastviewed(u *User, toUser *User, args []string, service string) {
-	if service == "slack" {
-		u.MsgUser(toUser, "not implemented")
-		return
-	}
-
 	channelID := ""
 
-	if len(args) != 1 {
-		u.MsgUser(toUser, "need UPDATELASTVIEWED <channel>")
-		u.MsgUser(toUser, "e.g. UPDATELASTVIEWED #bugs")
-		return
-	}
-
 	if strings.Contains(args[0], "#") {
 		args[0] = strings.ReplaceAll(args[0], "#", "")
 
@@ -510,15 +530,138 @@ func updatelastviewed(u *User, toUser *User, args []string, service string) {
 	u.MsgUser(toUser, fmt.Sprintf("set viewed for %s", args[0]))
 }
 
-var cmds = map[string]Command{
-	"lastsent":         {handler: lastsent, login: true, minParams: 0, maxParams: 0},
-	"logout":           {handler: logout, login: true, minParams: 0, maxParams: 0},
-	"login":            {handler: login, minParams: 2, maxParams: 5},
-	"part":             {handler: part, login: true, minParams: 1, maxParams: 1},
-	"search":           {handler: search, login: true, minParams: 1, maxParams: -1},
-	"searchusers":      {handler: searchUsers, login: true, minParams: 1, maxParams: -1},
-	"scrollback":       {handler: scrollback, login: true, minParams: 2, maxParams: 2},
-	"updatelastviewed": {handler: updatelastviewed, login: true, minParams: 1, maxParams: 1},
+var cmds map[string]Command
+
+// the handlers use cmds (eg for their usage), so it's filled in init.
+func init() {
+	cmds = map[string]Command{
+		"categories": {
+			handler: categories, login: true, minParams: 0, maxParams: -1,
+			args: "[#<channel>|<user> <category>]", description: "show your categories or move a channel to one",
+			protocols: []string{"mattermost"},
+		},
+		"follow": {
+			handler: follow, login: true, minParams: 1, maxParams: 1,
+			args: "@@<post ID>", description: "follow a thread", protocols: []string{"mattermost"},
+		},
+		"get": {
+			handler: get, login: true, minParams: 1, maxParams: 1,
+			args: "<setting>", description: "show one of your settings",
+		},
+		"help": {
+			handler: help, minParams: 0, maxParams: 1,
+			args: "[<command>]", description: "show the commands or how to use one",
+		},
+		"lastsent": {
+			handler: lastsent, login: true, minParams: 0, maxParams: 0,
+			description: "show your last sent messages with their IDs", protocols: []string{"mattermost"},
+		},
+		"logout": {
+			handler: logout, login: true, minParams: 0, maxParams: 1,
+			args: "[revoke]", description: "log out, revoke also ends the session on the server",
+		},
+		"login": {
+			handler: login, minParams: 0, maxParams: 5,
+			protocolArgs: map[string]string{
+				"mattermost": "<server> <team> <login> <pass>|token=<token> [MFAToken=<token>]",
+				"slack":      "<token> | <team> <login> <pass>",
+			},
+			description: "log in, add network=<name> to use another account too",
+		},
+		"markread": {
+			handler: markread, login: true, minParams: 1, maxParams: 1,
+			args: "all|#<channel>|<user>", description: "mark everything, a channel or the messages of a user as read",
+			protocols: []string{"mattermost"},
+		},
+		"mute": {
+			handler: mute, login: true, minParams: 0, maxParams: 1,
+			args: "[#<channel>]", description: "list the muted channels or mute a channel", protocols: []string{"mattermost"},
+		},
+		"part": {
+			handler: part, login: true, minParams: 1, maxParams: 1,
+			args: "#<channel>", description: "leave a channel", protocols: []string{"mattermost", "slack"},
+		},
+		"search": {
+			handler: search, login: true, minParams: 1, maxParams: -1,
+			args: "<query>", description: "search posts", protocols: []string{"mattermost"},
+		},
+		"searchusers": {
+			handler: searchUsers, login: true, minParams: 1, maxParams: -1,
+			args: "<query>", description: "search users", protocols: []string{"mattermost"},
+		},
+		"scrollback": {
+			handler: scrollback, login: true, minParams: 1, maxParams: 2,
+			args: "#<channel>|<user>|<post/thread ID> [<lines>]", description: "show the last messages of a channel, user or thread",
+			protocols: []string{"mattermost"},
+		},
+		"set": {
+			handler: set, login: true, minParams: 2, maxParams: -1,
+			args: "<setting> <value>", description: "change one of your settings",
+		},
+		"settings": {
+			handler: settings, login: true, minParams: 0, maxParams: 0,
+			description: "show your settings",
+		},
+		"threads": {
+			handler: threads, login: true, minParams: 0, maxParams: 1,
+			args: "[unread]", description: "list the threads you follow", protocols: []string{"mattermost"},
+		},
+		"unfollow": {
+			handler: unfollow, login: true, minParams: 1, maxParams: 1,
+			args: "@@<post ID>", description: "stop following a thread", protocols: []string{"mattermost"},
+		},
+		"unmute": {
+			handler: unmute, login: true, minParams: 1, maxParams: 1,
+			args: "#<channel>", description: "unmute a channel", protocols: []string{"mattermost"},
+		},
+		"unread": {
+			handler: unread, login: true, minParams: 0, maxParams: 1,
+			args: "[#<channel>]", description: "list the channels with unread messages or replay the unread ones of a channel",
+			protocols: []string{"mattermost"},
+		},
+		"unset": {
+			handler: unset, login: true, minParams: 1, maxParams: 1,
+			args: "<setting>", description: "use the value of the config for a setting again",
+		},
+		"updatelastviewed": {
+			handler: updatelastviewed, login: true, minParams: 1, maxParams: 1,
+			args: "#<channel>|<user>", description: "mark a channel or user as read", protocols: []string{"mattermost"},
+		},
+		"vchannel": {
+			handler: vchannel, login: true, minParams: 0, maxParams: -1,
+			args: "[&<name> [<channel regexp>...]]", description: "list, add (with regexps) or remove (without) a virtual channel",
+		},
+	}
+}
+
+func help(u *User, toUser *User, args []string, service string) {
+	if len(args) == 1 {
+		name := strings.ToLower(args[0])
+
+		cmd, ok := cmds[name]
+		if !ok || !cmd.supports(service) {
+			u.MsgUser(toUser, "unknown command "+args[0]+", HELP shows the commands")
+			return
+		}
+
+		u.MsgUser(toUser, commandUsage(name, service))
+		u.MsgUser(toUser, cmd.description)
+
+		return
+	}
+
+	names := make([]string, 0, len(cmds))
+	for name, cmd := range cmds {
+		if cmd.supports(service) {
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		u.MsgUser(toUser, commandUsage(name, service)+" - "+cmds[name].description)
+	}
 }
 
 func (u *User) handleServiceBot(service string, toUser *User, msg string) {
@@ -529,34 +672,66 @@ func (u *User) handleServiceBot(service string, toUser *User, msg string) {
 		return
 	}
 
-	cmd, ok := cmds[strings.ToLower(commands[0])]
+	if len(commands) == 0 {
+		commands = []string{"help"}
+	}
+
+	network, args := networkArg(commands[1:])
+	commands = append(commands[:1], args...)
+
+	name := strings.ToLower(commands[0])
+
+	cmd, ok := cmds[name]
 	if !ok {
-		keys := make([]string, 0)
-		for k := range cmds {
-			keys = append(keys, k)
-		}
-		u.MsgUser(toUser, "possible commands: "+strings.Join(keys, ", "))
-		u.MsgUser(toUser, "<command> help for more info")
+		u.MsgUser(toUser, "unknown command "+commands[0]+", the commands are:")
+		help(u, toUser, nil, service)
+		return
+	}
+
+	if !cmd.supports(service) {
+		u.MsgUser(toUser, fmt.Sprintf("%s is not supported on %s", name, service))
 		return
 	}
 
+	if network != "" {
+		switch {
+		case !networkNameRegExp.MatchString(network):
+			u.MsgUser(toUser, fmt.Sprintf("invalid network name %s", network))
+			return
+		case cmd.login && u.getNetwork(network) == nil:
+			u.MsgUser(toUser, fmt.Sprintf("network %s does not exist", network))
+			return
+		}
+
+		if existing := u.getNetwork(network); existing != nil {
+			u = existing
+		}
+	}
+
 	if cmd.login {
 		if u.br == nil {
 			u.MsgUser(toUser, "You're not logged in. Use LOGIN first.")
 			return
 		}
 	}
-	/*
-		if cmd.minParams > len(commands[1:]) {
-			u.MsgUser(toUser, fmt.Sprintf("%s requires at least %v arguments", commands[0], cmd.minParams))
-			return
-		}
-	*/
-	if cmd.maxParams > -1 && len(commands[1:]) > cmd.maxParams {
-		u.MsgUser(toUser, fmt.Sprintf("%s takes at most %v arguments", commands[0], cmd.maxParams))
+
+	if len(commands[1:]) < cmd.minParams || (cmd.maxParams > -1 && len(commands[1:]) > cmd.maxParams) {
+		u.MsgUser(toUser, "need "+commandUsage(name, service))
 		return
 	}
 
+	// a new network only sticks around when logging in to it worked.
+	if network != "" && u.network == "" {
+		parent := u
+		u = parent.addNetwork(network)
+
+		defer func() {
+			if u.br == nil {
+				parent.removeNetwork(u.network)
+			}
+		}()
+	}
+
 	cmd.handler(u, toUser, commands[1:], service)
 }
 
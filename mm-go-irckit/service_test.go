@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"testing"
 
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -104,3 +106,53 @@ func TestParseCommandString(t *testing.T) {
 		}
 	}
 }
+
+func TestHandleServiceBot(t *testing.T) {
+	SetLogger(logrus.NewEntry(logrus.New()))
+
+	c, client := newTestClient()
+	u := newBridgeUser(newSessionConn(c), NewServer("matterircd"), viper.New(), nil)
+	u.Nick, u.User = "me", "me"
+	u.createService("slack", "loginservice")
+
+	slack, _ := u.Srv.HasUser("slack")
+
+	for _, tc := range []struct {
+		msg  string
+		want []string
+	}{
+		{msg: "search foo", want: []string{"search is not supported on slack"}},
+		{msg: "help scrollback", want: []string{"unknown command scrollback, HELP shows the commands"}},
+		{msg: "help part", want: []string{"PART #<channel>", "leave a channel"}},
+		{msg: "help login", want: []string{"LOGIN <token> | <team> <login> <pass>", "log in, add network=<name> to use another account too"}},
+		{msg: "part #bugs", want: []string{"You're not logged in. Use LOGIN first."}},
+		{msg: "help part logout", want: []string{"need HELP [<command>]"}},
+		{msg: "help part network=work", want: []string{"PART #<channel>", "leave a channel"}},
+		{msg: "login network=work a b c d e", want: []string{"need LOGIN <team> <login> <pass> or LOGIN <token>"}},
+		{msg: "nope", want: []string{
+			"unknown command nope, the commands are:",
+			"GET <setting> - show one of your settings",
+			"HELP [<command>] - show the commands or how to use one",
+			"LOGIN <token> | <team> <login> <pass> - log in, add network=<name> to use another account too",
+			"LOGOUT [revoke] - log out, revoke also ends the session on the server",
+			"PART #<channel> - leave a channel",
+			"SET <setting> <value> - change one of your settings",
+			"SETTINGS - show your settings",
+			"UNSET <setting> - use the value of the config for a setting again",
+			"VCHANNEL [&<name> [<channel regexp>...]] - list, add (with regexps) or remove (without) a virtual channel",
+		}},
+	} {
+		go u.handleServiceBot("slack", slack, tc.msg)
+
+		for _, line := range tc.want {
+			client.expect(t, ":slack!slack@service PRIVMSG me :"+line)
+		}
+	}
+
+	assert.Nil(t, u.getNetwork("work"))
+
+	u.br = &protocolBridge{protocol: "slack"}
+
+	go u.handleServiceBot("slack", slack, "part")
+	client.expect(t, ":slack!slack@service PRIVMSG me :need PART #<channel>")
+}
@@ -0,0 +1,673 @@
+package irckit
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/42wim/matterircd/bridge"
+	"github.com/sorcix/irc"
+)
+
+// Bouncer mode keeps the bridge of a user running when the IRC client disconnects.
+// Every IRC connection reads and writes through a sessionConn, when a client logs in
+// to an account which already has a session, its connection is moved to the session
+// and the temporary user of the connection is dropped.
+// A session can have multiple clients, they all get the messages of the session.
+
+// supportedCaps are the IRCv3 capabilities a client can request.
+var supportedCaps = []string{"echo-message"}
+
+// clientConn is the connection of an IRC client, it reads messages for the
+// sessionConn it's currently attached to.
+// Nick and capabilities are kept per client.
+type clientConn struct {
+	Conn
+
+	mu    sync.RWMutex
+	owner *sessionConn
+	id    string
+	nick  string
+	caps  map[string]bool
+
+	encodeMutex sync.Mutex
+}
+
+func newClientConn(c Conn) *clientConn {
+	return &clientConn{
+		Conn: c,
+		caps: make(map[string]bool),
+	}
+}
+
+func (c *clientConn) getOwner() *sessionConn {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.owner
+}
+
+func (c *clientConn) setOwner(owner *sessionConn) {
+	c.mu.Lock()
+	c.owner = owner
+	c.mu.Unlock()
+}
+
+// getID returns the username the client registered with.
+func (c *clientConn) getID() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.id
+}
+
+func (c *clientConn) getNick() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.nick
+}
+
+func (c *clientConn) hasCap(name string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.caps[name]
+}
+
+func (c *clientConn) Encode(msg *irc.Message) error {
+	c.trackNick(msg)
+
+	c.encodeMutex.Lock()
+	defer c.encodeMutex.Unlock()
+
+	return c.Conn.Encode(msg)
+}
+
+// trackNick keeps the nick the client thinks it has.
+func (c *clientConn) trackNick(msg *irc.Message) {
+	if len(msg.Params) == 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch msg.Command {
+	case irc.RPL_WELCOME:
+		c.nick = msg.Params[0]
+	case irc.NICK:
+		if msg.Prefix != nil && strings.EqualFold(msg.Prefix.Name, c.nick) {
+			c.nick = msg.Params[0]
+		}
+	}
+}
+
+func (c *clientConn) readLoop() {
+	for {
+		msg, err := c.Conn.Decode()
+		if err != nil {
+			c.getOwner().clientGone(c)
+			return
+		}
+
+		if msg == nil {
+			continue
+		}
+
+		switch msg.Command {
+		case irc.CAP:
+			c.handleCap(msg)
+			continue
+		case irc.USER:
+			if len(msg.Params) > 0 {
+				c.mu.Lock()
+				c.id = msg.Params[0]
+				c.mu.Unlock()
+			}
+		case irc.QUIT:
+			if owner := c.getOwner(); owner.isPersistent() {
+				c.Encode(&irc.Message{ //nolint:errcheck
+					Command:  irc.ERROR,
+					Trailing: "Detached, your session keeps running.",
+				})
+				owner.clientGone(c)
+				c.Conn.Close()
+
+				return
+			}
+		}
+
+		var echo *irc.Message
+		if msg.Command == irc.PRIVMSG || msg.Command == irc.NOTICE {
+			// copy it, the paste buffer modifies msg.
+			echo = &irc.Message{
+				Command:       msg.Command,
+				Params:        msg.Params,
+				Trailing:      msg.Trailing,
+				EmptyTrailing: msg.EmptyTrailing,
+			}
+		}
+
+		if !c.deliver(msg) {
+			return
+		}
+
+		if echo != nil {
+			c.getOwner().echo(c, echo)
+		}
+	}
+}
+
+// deliver sends msg to the owner, following the client when it's attached
+// to another session in the meantime.
+func (c *clientConn) deliver(msg *irc.Message) bool {
+	for {
+		owner := c.getOwner()
+
+		select {
+		case owner.in <- msg:
+			return true
+		case <-owner.closed:
+			if c.getOwner() == owner {
+				c.Conn.Close()
+				return false
+			}
+		}
+	}
+}
+
+// handleCap handles the capability negotiation of the client.
+func (c *clientConn) handleCap(msg *irc.Message) {
+	if len(msg.Params) == 0 {
+		return
+	}
+
+	var prefix *irc.Prefix
+	if u := c.getOwner().user; u != nil && u.Srv != nil {
+		prefix = u.Srv.Prefix()
+	}
+
+	nick := c.getNick()
+	if nick == "" {
+		nick = "*"
+	}
+
+	reply := func(subcommand string, trailing string) {
+		c.Encode(&irc.Message{ //nolint:errcheck
+			Prefix:        prefix,
+			Command:       irc.CAP,
+			Params:        []string{nick, subcommand},
+			Trailing:      trailing,
+			EmptyTrailing: true,
+		})
+	}
+
+	subcommand := strings.ToUpper(msg.Params[0])
+
+	switch subcommand {
+	case irc.CAP_LS:
+		reply(irc.CAP_LS, strings.Join(supportedCaps, " "))
+	case irc.CAP_LIST:
+		c.mu.RLock()
+		enabled := []string{}
+		for _, name := range supportedCaps {
+			if c.caps[name] {
+				enabled = append(enabled, name)
+			}
+		}
+		c.mu.RUnlock()
+
+		reply(irc.CAP_LIST, strings.Join(enabled, " "))
+	case irc.CAP_REQ:
+		requested := msg.Trailing
+		if requested == "" && len(msg.Params) > 1 {
+			requested = msg.Params[1]
+		}
+
+		if !c.requestCaps(strings.Fields(requested)) {
+			reply(irc.CAP_NAK, requested)
+			return
+		}
+
+		reply(irc.CAP_ACK, requested)
+	case irc.CAP_END:
+	default:
+		// github.com/sorcix/irc doesn't yet support ERR_INVALIDCAPCMD (410)
+		c.Encode(&irc.Message{ //nolint:errcheck
+			Prefix:   prefix,
+			Command:  "410",
+			Params:   []string{nick, subcommand},
+			Trailing: "Invalid or unsupported CAP command",
+		})
+	}
+}
+
+// requestCaps enables (or disables when prefixed with -) the capabilities in names,
+// nothing changes if one of them isn't supported.
+func (c *clientConn) requestCaps(names []string) bool {
+	for _, name := range names {
+		if !isSupportedCap(strings.TrimPrefix(name, "-")) {
+			return false
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, name := range names {
+		if strings.HasPrefix(name, "-") {
+			delete(c.caps, name[1:])
+			continue
+		}
+
+		c.caps[name] = true
+	}
+
+	return true
+}
+
+func isSupportedCap(name string) bool {
+	for _, supported := range supportedCaps {
+		if name == supported {
+			return true
+		}
+	}
+
+	return false
+}
+
+// sessionConn is the Conn of a user, it's used by all IRC clients attached to the session.
+type sessionConn struct {
+	mu         sync.RWMutex
+	clients    []*clientConn
+	persistent bool
+	host       string
+	user       *User
+
+	// sequence numbers in the backlog.
+	lastSeq    uint64
+	detachedAt uint64
+	detached   bool
+
+	in        chan *irc.Message
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newSessionConn(c Conn) *sessionConn {
+	sc := &sessionConn{
+		in:     make(chan *irc.Message),
+		closed: make(chan struct{}),
+	}
+
+	client := newClientConn(c)
+	sc.attach(client)
+
+	go client.readLoop()
+
+	return sc
+}
+
+func (sc *sessionConn) getClients() []*clientConn {
+	sc.mu.RLock()
+	defer sc.mu.RUnlock()
+
+	return append([]*clientConn{}, sc.clients...)
+}
+
+// Encode sends msg to all clients, a client failing doesn't stop the others
+// from getting it, its connection gets cleaned up by its readLoop.
+func (sc *sessionConn) Encode(msg *irc.Message) error {
+	if sc.isPersistent() && sc.user != nil {
+		if seq, ok := sc.user.recordBacklog(msg); ok {
+			sc.mu.Lock()
+			if seq > sc.lastSeq {
+				sc.lastSeq = seq
+			}
+			sc.mu.Unlock()
+		}
+	}
+
+	for _, client := range sc.getClients() {
+		if err := client.Encode(msg); err != nil {
+			logger.Debugf("encoding to client failed: %s", err)
+		}
+	}
+
+	return nil
+}
+
+func (sc *sessionConn) Decode() (*irc.Message, error) {
+	select {
+	case msg := <-sc.in:
+		return msg, nil
+	case <-sc.closed:
+		return nil, io.EOF
+	}
+}
+
+// Close ends the session and closes the connections of the clients.
+func (sc *sessionConn) Close() error {
+	sc.closeOnce.Do(func() {
+		close(sc.closed)
+	})
+
+	for _, client := range sc.takeClients() {
+		client.Conn.Close()
+	}
+
+	return nil
+}
+
+// ResolveHost returns the host of the client which started the session.
+func (sc *sessionConn) ResolveHost() string {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if sc.host == "" && len(sc.clients) > 0 {
+		sc.host = sc.clients[0].ResolveHost()
+	}
+
+	return sc.host
+}
+
+func (sc *sessionConn) isPersistent() bool {
+	sc.mu.RLock()
+	defer sc.mu.RUnlock()
+
+	return sc.persistent
+}
+
+func (sc *sessionConn) setPersistent(persistent bool) {
+	sc.mu.Lock()
+	sc.persistent = persistent
+	sc.mu.Unlock()
+}
+
+// attach adds client to the clients of this session.
+func (sc *sessionConn) attach(client *clientConn) {
+	client.setOwner(sc)
+
+	sc.mu.Lock()
+	sc.clients = append(sc.clients, client)
+	sc.mu.Unlock()
+}
+
+// takeClients removes all clients from the session without closing their connections.
+func (sc *sessionConn) takeClients() []*clientConn {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	clients := sc.clients
+	sc.clients = nil
+
+	return clients
+}
+
+// clientGone is called when the connection of client is gone.
+func (sc *sessionConn) clientGone(client *clientConn) {
+	sc.mu.Lock()
+	found := false
+	for i, c := range sc.clients {
+		if c == client {
+			sc.clients = append(sc.clients[:i], sc.clients[i+1:]...)
+			found = true
+			break
+		}
+	}
+	left := len(sc.clients)
+	persistent := sc.persistent
+	lastSeq := sc.lastSeq
+	if found && left == 0 {
+		sc.detachedAt, sc.detached = lastSeq, true
+	}
+	sc.mu.Unlock()
+
+	if found && persistent && sc.user != nil {
+		sc.user.saveBacklogPosition(client.getID(), lastSeq)
+	}
+
+	if !found || left > 0 {
+		return
+	}
+
+	if !persistent {
+		sc.Close()
+		return
+	}
+
+	logger.Debug("last client detached from session")
+}
+
+// detachedPosition returns the backlog position of when the last client detached.
+func (sc *sessionConn) detachedPosition() (uint64, bool) {
+	sc.mu.RLock()
+	defer sc.mu.RUnlock()
+
+	return sc.detachedAt, sc.detached
+}
+
+// echo sends msg sent by from to the other clients of the session, and back to
+// from when it requested echo-message.
+func (sc *sessionConn) echo(from *clientConn, msg *irc.Message) {
+	u := sc.user
+	if u == nil || len(msg.Params) == 0 {
+		return
+	}
+
+	// don't echo logins to the services.
+	if other, ok := u.Srv.HasUser(msg.Params[0]); ok && other.Host == "service" {
+		return
+	}
+
+	for _, client := range sc.getClients() {
+		if client == from && !client.hasCap("echo-message") {
+			continue
+		}
+
+		prefix := u.Prefix()
+		if nick := client.getNick(); nick != "" {
+			prefix.Name = nick
+		}
+
+		client.Encode(&irc.Message{ //nolint:errcheck
+			Prefix:        prefix,
+			Command:       msg.Command,
+			Params:        msg.Params,
+			Trailing:      msg.Trailing,
+			EmptyTrailing: msg.EmptyTrailing,
+		})
+	}
+}
+
+type sessionManager struct {
+	sync.Mutex
+	sessions map[string]*User
+
+	// running are the users of all connections, logged in or not.
+	running     map[*User]runningSession
+	runningDone sync.WaitGroup
+	runningID   int
+}
+
+var sessions = &sessionManager{
+	sessions: make(map[string]*User),
+	running:  make(map[*User]runningSession),
+}
+
+// sessionKey returns the key of the account logged in with cred.
+func sessionKey(protocol string, cred bridge.Credentials) string {
+	login := strings.ToLower(cred.Login)
+	if login == "" && cred.Token != "" {
+		sum := sha256.Sum256([]byte(cred.Token))
+		login = "token:" + hex.EncodeToString(sum[:])
+	}
+
+	return strings.Join([]string{protocol, strings.ToLower(cred.Server), strings.ToLower(cred.Team), login}, "|")
+}
+
+func (m *sessionManager) get(key string) *User {
+	m.Lock()
+	defer m.Unlock()
+
+	return m.sessions[key]
+}
+
+func (m *sessionManager) add(key string, u *User) {
+	m.Lock()
+	for k, sess := range m.sessions {
+		if sess == u {
+			delete(m.sessions, k)
+		}
+	}
+	old := m.sessions[key]
+	m.sessions[key] = u
+	m.Unlock()
+
+	if old != nil && old != u {
+		logger.Debugf("replacing session %s", key)
+		old.endSession()
+	}
+}
+
+func (m *sessionManager) remove(u *User) {
+	m.Lock()
+	defer m.Unlock()
+
+	for key, sess := range m.sessions {
+		if sess == u {
+			delete(m.sessions, key)
+		}
+	}
+}
+
+// sameCredentials checks if the secrets of a and b match.
+func sameCredentials(a, b bridge.Credentials) bool {
+	return subtle.ConstantTimeCompare([]byte(a.Pass), []byte(b.Pass)) == 1 &&
+		subtle.ConstantTimeCompare([]byte(a.Token), []byte(b.Token)) == 1
+}
+
+// attachSession moves the IRC client of u to an existing session of the account
+// u is logging in to. Returns false if there's no (usable) session.
+func (u *User) attachSession(protocol string) bool {
+	sc, ok := u.Conn.(*sessionConn)
+	if !ok || !u.config().GetBool("bouncer") || u.parent != nil {
+		return false
+	}
+
+	sess := sessions.get(sessionKey(protocol, u.Credentials))
+	if sess == nil || sess == u {
+		return false
+	}
+
+	if sess.br == nil || !sess.br.Connected() {
+		sessions.remove(sess)
+		return false
+	}
+
+	// let the bridge check the credentials with a new login, that session replaces the old one.
+	if !sameCredentials(sess.Credentials, u.Credentials) {
+		logger.Infof("credentials for session of %s differ, not attaching", u.Credentials.Login)
+		return false
+	}
+
+	clients := sc.takeClients()
+	if len(clients) == 0 {
+		return false
+	}
+
+	target := sess.Conn.(*sessionConn)
+	for _, client := range clients {
+		target.attach(client)
+	}
+	// the temporary user of this connection isn't needed anymore.
+	sc.Close()
+
+	for _, client := range clients {
+		sess.syncClient(client)
+		sess.replayBacklog(client)
+	}
+
+	return true
+}
+
+// startSession registers u as session, so it outlives the IRC connection.
+func (u *User) startSession(protocol string) {
+	sc, ok := u.Conn.(*sessionConn)
+	if !ok || !u.config().GetBool("bouncer") || u.parent != nil {
+		return
+	}
+
+	sc.setPersistent(true)
+	sessions.add(sessionKey(protocol, u.Credentials), u)
+}
+
+// stopSession stops keeping u running when the IRC client disconnects.
+func (u *User) stopSession() {
+	// the session belongs to the IRC connection, not to a network.
+	if u.parent != nil {
+		return
+	}
+
+	sessions.remove(u)
+
+	if sc, ok := u.Conn.(*sessionConn); ok {
+		sc.setPersistent(false)
+	}
+}
+
+// endSession logs out the bridge of u and disconnects its client.
+func (u *User) endSession() {
+	u.stopSession()
+
+	if u.br != nil {
+		u.br.Logout()
+	}
+
+	u.Conn.Close()
+}
+
+// syncClient brings a newly attached client up to date.
+func (u *User) syncClient(client *clientConn) {
+	// only send to this client.
+	view := NewUser(client)
+	view.UserInfo = u.UserInfo
+	view.Srv = u.Srv
+
+	if nick := client.getNick(); nick != "" && nick != u.Nick {
+		view.Encode(&irc.Message{ //nolint:errcheck
+			Prefix:  &irc.Prefix{Name: nick, User: u.User, Host: u.Host},
+			Command: irc.NICK,
+			Params:  []string{u.Nick},
+		})
+	}
+
+	channels := u.Channels()
+	for _, network := range u.getNetworks() {
+		channels = append(channels, network.Channels()...)
+	}
+
+	for _, ch := range channels {
+		view.Encode(&irc.Message{ //nolint:errcheck
+			Prefix:  u.Prefix(),
+			Command: irc.JOIN,
+			Params:  []string{ch.String()},
+		})
+
+		if topic := ch.GetTopic(); topic != "" {
+			u.Srv.EncodeMessage(view, irc.RPL_TOPIC, []string{u.Nick, ch.String()}, topic) //nolint:errcheck
+		}
+
+		ch.SendNamesResponse(view) //nolint:errcheck
+	}
+
+	if u.br != nil {
+		if svc, ok := u.Srv.HasUser(u.br.Protocol()); ok {
+			view.MsgUser(svc, "attached to existing session")
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package irckit
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/42wim/matterircd/bridge"
+	"github.com/sirupsen/logrus"
+	"github.com/sorcix/irc"
+	"github.com/stretchr/testify/assert"
+)
+
+type testClient struct {
+	net.Conn
+	lines chan string
+}
+
+func newTestClient() (*conn, *testClient) {
+	server, client := net.Pipe()
+
+	tc := &testClient{Conn: client, lines: make(chan string, 10)}
+
+	go func() {
+		r := bufio.NewReader(client)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				close(tc.lines)
+				return
+			}
+			tc.lines <- strings.TrimSpace(line)
+		}
+	}()
+
+	return &conn{
+		Conn:    server,
+		Encoder: irc.NewEncoder(server),
+		Decoder: irc.NewDecoder(server),
+	}, tc
+}
+
+func (tc *testClient) expect(t *testing.T, want string) {
+	t.Helper()
+
+	select {
+	case line := <-tc.lines:
+		assert.Equal(t, want, line)
+	case <-time.After(time.Second):
+		t.Fatalf("timeout waiting for %q", want)
+	}
+}
+
+func TestSessionConn(t *testing.T) {
+	SetLogger(logrus.NewEntry(logrus.New()))
+
+	c1, client1 := newTestClient()
+	sc := newSessionConn(c1)
+	sc.setPersistent(true)
+
+	u := NewUser(sc)
+	u.Srv = NewServer("matterircd")
+	u.Nick, u.User, u.Host = "me", "me", "host"
+	sc.user = u
+
+	// a second client attaches to the session.
+	c2, client2 := newTestClient()
+	other := newSessionConn(c2)
+	for _, client := range other.takeClients() {
+		sc.attach(client)
+	}
+	other.Close()
+
+	_, err := other.Decode()
+	assert.Equal(t, io.EOF, err)
+
+	go client2.Write([]byte("CAP REQ :echo-message\r\n")) //nolint:errcheck
+	client2.expect(t, ":matterircd CAP * ACK :echo-message")
+
+	sc.Encode(&irc.Message{Command: irc.PING, Trailing: "both"}) //nolint:errcheck
+	client1.expect(t, "PING :both")
+	client2.expect(t, "PING :both")
+
+	// messages of a client are echoed to the others.
+	go client1.Write([]byte("PRIVMSG #test :hello\r\n")) //nolint:errcheck
+	msg, err := sc.Decode()
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", msg.Trailing)
+	client2.expect(t, ":me!me@host PRIVMSG #test :hello")
+
+	// and to the sender with echo-message.
+	go client2.Write([]byte("PRIVMSG #test :back\r\n")) //nolint:errcheck
+	msg, err = sc.Decode()
+	assert.NoError(t, err)
+	assert.Equal(t, "back", msg.Trailing)
+	client1.expect(t, ":me!me@host PRIVMSG #test :back")
+	client2.expect(t, ":me!me@host PRIVMSG #test :back")
+
+	// the session survives a client quitting.
+	go client1.Write([]byte("QUIT :bye\r\n")) //nolint:errcheck
+	client1.expect(t, "ERROR :Detached, your session keeps running.")
+
+	sc.Encode(&irc.Message{Command: irc.PING, Trailing: "still here"}) //nolint:errcheck
+	client2.expect(t, "PING :still here")
+
+	// without bouncer mode the session ends with the last client.
+	sc.setPersistent(false)
+	client2.Close()
+
+	select {
+	case <-sc.closed:
+	case <-time.After(time.Second):
+		t.Fatal("session not closed")
+	}
+}
+
+func TestSessionKey(t *testing.T) {
+	cred := func(login, token string) bridge.Credentials {
+		return bridge.Credentials{Server: "Chat.example.com", Team: "team", Login: login, Token: token}
+	}
+
+	assert.Equal(t, "mattermost|chat.example.com|team|john", sessionKey("mattermost", cred("John", "")))
+	assert.NotContains(t, sessionKey("slack", cred("", "xoxp-secret")), "secret")
+	assert.NotEqual(t, sessionKey("slack", cred("", "a")), sessionKey("slack", cred("", "b")))
+}
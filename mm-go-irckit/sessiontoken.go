@@ -0,0 +1,192 @@
+package irckit
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"strings"
+
+	"github.com/42wim/matterircd/bridge"
+	bolt "go.etcd.io/bbolt"
+	"golang.org/x/crypto/scrypt"
+)
+
+// The session tokens of password logins are kept in the database, so the next login
+// (eg after a restart) doesn't need the MFA token again. They're encrypted with a key
+// derived from the password, only the same password gets the session back.
+// For slack that's the xoxc token/cookie of a team/login/password login.
+var sessionTokensBucket = []byte("sessiontokens")
+
+const sessionTokenSaltSize = 16
+
+var errInvalidSessionToken = errors.New("invalid session token")
+
+// sessionTokenKey returns the key the session of a login with cred is kept under.
+// Logins with a token (mattermost token=, slack xoxp/xoxc) aren't kept: the token given
+// at login already is the session and there's no password to encrypt it with.
+func sessionTokenKey(protocol string, cred bridge.Credentials) (string, bool) {
+	if cred.Pass == "" || cred.Token != "" || strings.Contains(strings.ToLower(cred.Pass), "token=") {
+		return "", false
+	}
+
+	return sessionKey(protocol, cred), true
+}
+
+func sessionTokenCipher(pass string, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(pass), salt, 1<<15, 8, 1, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// encryptSessionToken returns token encrypted with pass as salt|nonce|ciphertext.
+func encryptSessionToken(token, pass string) ([]byte, error) {
+	salt := make([]byte, sessionTokenSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	aead, err := sessionTokenCipher(pass, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	data := append(salt, nonce...) //nolint:gocritic
+
+	return aead.Seal(data, nonce, []byte(token), nil), nil
+}
+
+func decryptSessionToken(data []byte, pass string) (string, error) {
+	if len(data) < sessionTokenSaltSize {
+		return "", errInvalidSessionToken
+	}
+
+	aead, err := sessionTokenCipher(pass, data[:sessionTokenSaltSize])
+	if err != nil {
+		return "", err
+	}
+
+	data = data[sessionTokenSaltSize:]
+	if len(data) < aead.NonceSize() {
+		return "", errInvalidSessionToken
+	}
+
+	token, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], nil)
+	if err != nil {
+		return "", errInvalidSessionToken
+	}
+
+	return string(token), nil
+}
+
+// storedSessionToken returns the session token kept for the login with cred.
+func (u *User) storedSessionToken(protocol string, cred bridge.Credentials) (string, bool) {
+	key, ok := sessionTokenKey(protocol, cred)
+	if !ok || u.lastViewedAtDB == nil {
+		return "", false
+	}
+
+	var data []byte
+
+	err := u.lastViewedAtDB.View(func(tx *bolt.Tx) error {
+		if b := tx.Bucket(sessionTokensBucket); b != nil {
+			data = append(data, b.Get([]byte(key))...)
+		}
+
+		return nil
+	})
+	if err != nil || len(data) == 0 {
+		return "", false
+	}
+
+	token, err := decryptSessionToken(data, cred.Pass)
+	if err != nil {
+		logger.Debugf("stored session token of %s not used: %s", cred.Login, err)
+		return "", false
+	}
+
+	return token, true
+}
+
+// saveSessionToken keeps the session token of br for the login with cred.
+func (u *User) saveSessionToken(protocol string, cred bridge.Credentials, br bridge.Bridger) {
+	keeper, ok := br.(bridge.SessionKeeper)
+	if !ok || keeper.SessionToken() == "" {
+		return
+	}
+
+	key, ok := sessionTokenKey(protocol, cred)
+	if !ok || u.lastViewedAtDB == nil {
+		return
+	}
+
+	data, err := encryptSessionToken(keeper.SessionToken(), cred.Pass)
+	if err != nil {
+		logger.Errorf("encrypting session token of %s failed: %s", cred.Login, err)
+		return
+	}
+
+	err = u.lastViewedAtDB.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(sessionTokensBucket)
+		if err != nil {
+			return err
+		}
+
+		return b.Put([]byte(key), data)
+	})
+	if err != nil {
+		logger.Errorf("saving session token of %s failed: %s", cred.Login, err)
+		return
+	}
+
+	keeper.KeepSession()
+}
+
+// deleteSessionToken removes the session token kept for the login with cred.
+func (u *User) deleteSessionToken(protocol string, cred bridge.Credentials) {
+	key, ok := sessionTokenKey(protocol, cred)
+	if !ok || u.lastViewedAtDB == nil {
+		return
+	}
+
+	err := u.lastViewedAtDB.Update(func(tx *bolt.Tx) error {
+		if b := tx.Bucket(sessionTokensBucket); b != nil {
+			return b.Delete([]byte(key))
+		}
+
+		return nil
+	})
+	if err != nil {
+		logger.Errorf("deleting session token of %s failed: %s", cred.Login, err)
+	}
+}
+
+// revokeSession ends the session of u on the server and forgets its token.
+func (u *User) revokeSession() error {
+	u.deleteSessionToken(u.br.Protocol(), u.Credentials)
+
+	br := u.br
+	if nb, ok := br.(*networkBridge); ok {
+		br = nb.Bridger
+	}
+
+	keeper, ok := br.(bridge.SessionKeeper)
+	if !ok {
+		return nil
+	}
+
+	return keeper.RevokeSession()
+}
@@ -0,0 +1,72 @@
+package irckit
+
+import (
+	"testing"
+
+	"github.com/42wim/matterircd/bridge"
+	"github.com/stretchr/testify/assert"
+	bolt "go.etcd.io/bbolt"
+)
+
+type keeperBridge struct {
+	bridge.Bridger
+	token string
+	kept  bool
+}
+
+func (b *keeperBridge) SessionToken() string {
+	return b.token
+}
+
+func (b *keeperBridge) KeepSession() {
+	b.kept = true
+}
+
+func (b *keeperBridge) RevokeSession() error {
+	return nil
+}
+
+func TestSessionToken(t *testing.T) {
+	db := newTestDB(t)
+
+	u := NewUser(nil)
+	u.lastViewedAtDB = db
+
+	cred := bridge.Credentials{Server: "chat.example.com", Team: "team", Login: "john", Pass: "secret", MFAToken: "123456"}
+
+	_, ok := sessionTokenKey("mattermost", bridge.Credentials{Login: "john", Pass: "token=personal"})
+	assert.False(t, ok, "token logins aren't kept")
+	_, ok = sessionTokenKey("slack", bridge.Credentials{Token: "xoxp-token"})
+	assert.False(t, ok, "token logins aren't kept")
+
+	_, ok = u.storedSessionToken("mattermost", cred)
+	assert.False(t, ok)
+
+	tokenLogin := &keeperBridge{token: "session"}
+	u.saveSessionToken("mattermost", bridge.Credentials{Login: "john", Pass: "token=personal"}, tokenLogin)
+	assert.False(t, tokenLogin.kept, "sessions which aren't stored end on logout")
+
+	keeper := &keeperBridge{token: "session"}
+	u.saveSessionToken("mattermost", cred, keeper)
+	assert.True(t, keeper.kept)
+
+	err := db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(sessionTokensBucket).Get([]byte(sessionKey("mattermost", cred)))
+		assert.NotContains(t, string(v), "session", "tokens are encrypted")
+		return nil
+	})
+	assert.NoError(t, err)
+
+	token, ok := u.storedSessionToken("mattermost", bridge.Credentials{Server: "chat.example.com", Team: "team", Login: "John", Pass: "secret"})
+	assert.True(t, ok)
+	assert.Equal(t, "session", token)
+
+	wrong := cred
+	wrong.Pass = "wrong"
+	_, ok = u.storedSessionToken("mattermost", wrong)
+	assert.False(t, ok, "only the same password decrypts the token")
+
+	u.deleteSessionToken("mattermost", cred)
+	_, ok = u.storedSessionToken("mattermost", cred)
+	assert.False(t, ok)
+}
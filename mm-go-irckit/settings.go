@@ -0,0 +1,440 @@
+package irckit
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/42wim/matterircd/bridge"
+	"github.com/spf13/viper"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Settings are kept per account in the database and override the settings of the
+// protocol section of the config (eg [mattermost]) for that account only.
+// The config of a logged in user is a copy of the global one with the settings on top,
+// changes take effect immediately.
+var settingsBucket = []byte("settings")
+
+type settingKind int
+
+const (
+	settingBool settingKind = iota
+	settingInt
+	settingString
+	settingList
+	settingRegexps
+	settingVirtualChannels
+)
+
+func (k settingKind) String() string {
+	switch k {
+	case settingBool:
+		return "true/false"
+	case settingInt:
+		return "number"
+	case settingList:
+		return "comma separated list"
+	case settingRegexps:
+		return "comma separated regexps"
+	case settingVirtualChannels:
+		return "&name=regexp,... ..."
+	default:
+		return "text"
+	}
+}
+
+// settingKinds are the settings users can change themselves.
+var settingKinds = map[string]settingKind{
+	"collapsescrollback":       settingBool,
+	"disableautoview":          settingBool,
+	"disableircemphasis":       settingBool,
+	"hidebots":                 settingBool,
+	"hidereactions":            settingBool,
+	"hidereplies":              settingBool,
+	"highlightchannelmentions": settingBool,
+	"highlightkeywords":        settingRegexps,
+	"highlights":               settingBool,
+	"joindm":                   settingBool,
+	"joinexclude":              settingRegexps,
+	"joininclude":              settingRegexps,
+	"joinonly":                 settingRegexps,
+	"mutedchannels":            settingString,
+	"partfake":                 settingBool,
+	"prefixcontext":            settingBool,
+	"shortenrepliesto":         settingInt,
+	"showcontextmulti":         settingBool,
+	"showmentions":             settingBool,
+	"showonlyjoined":           settingBool,
+	"suffixcontext":            settingBool,
+	"syntaxhighlighting":       settingString,
+	"threadcontext":            settingString,
+	"threadsonly":              settingBool,
+	"translatementions":        settingBool,
+	"unicode":                  settingBool,
+	"virtualchannels":          settingVirtualChannels,
+	"virtualchannelsmove":      settingBool,
+}
+
+// parseSetting validates value(s) for setting key and returns it with the right type.
+func parseSetting(key string, values []string) (interface{}, error) {
+	kind, ok := settingKinds[key]
+	if !ok {
+		return nil, fmt.Errorf("unknown setting %s", key)
+	}
+
+	value := strings.Join(values, " ")
+
+	switch kind {
+	case settingBool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s needs true or false", key)
+		}
+
+		return b, nil
+	case settingInt:
+		i, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s needs a number", key)
+		}
+
+		return i, nil
+	case settingList, settingRegexps:
+		// only comma separated, the entries can have spaces (eg regexps).
+		list := []string{}
+
+		for _, entry := range strings.Split(value, ",") {
+			if entry = strings.TrimSpace(entry); entry != "" {
+				list = append(list, entry)
+			}
+		}
+
+		if kind == settingRegexps {
+			for _, entry := range list {
+				if _, err := regexp.Compile(entry); err != nil {
+					return nil, fmt.Errorf("%s: %s", key, err)
+				}
+			}
+		}
+
+		return list, nil
+	case settingVirtualChannels:
+		return parseVirtualChannels(values)
+	default:
+		return value, nil
+	}
+}
+
+// copyConfig returns a copy of v which can be changed without changing v.
+func copyConfig(v *viper.Viper) *viper.Viper {
+	cv := viper.New()
+	refreshConfig(cv, v) //nolint:errcheck
+
+	return cv
+}
+
+// refreshConfig replaces the config of copy cv with the one of v, what's Set on cv stays.
+func refreshConfig(cv *viper.Viper, v *viper.Viper) error {
+	data, err := json.Marshal(v.AllSettings())
+	if err != nil {
+		return err
+	}
+
+	cv.SetConfigType("json")
+
+	return cv.ReadConfig(bytes.NewReader(data))
+}
+
+// configChange is a config being built for a user, what's Set on it is kept as an
+// override when the config is built again.
+type configChange struct {
+	*viper.Viper
+	overrides map[string]interface{}
+}
+
+// Set sets key to value, a nil value falls through to the base config again.
+func (c *configChange) Set(key string, value interface{}) {
+	c.Viper.Set(key, value)
+
+	if value == nil {
+		delete(c.overrides, strings.ToLower(key))
+		return
+	}
+
+	c.overrides[strings.ToLower(key)] = value
+}
+
+// config returns the config of u. It's replaced instead of changed (bridges read it from
+// their own goroutines), what's returned is never changed.
+func (u *User) config() *viper.Viper {
+	u.vMutex.RLock()
+	defer u.vMutex.RUnlock()
+
+	return u.v
+}
+
+// setConfig makes v the config of u and of its bridge.
+func (u *User) setConfig(v *viper.Viper) {
+	u.vMutex.Lock()
+	u.v = v
+	u.vMutex.Unlock()
+
+	br := u.br
+	if nb, ok := br.(*networkBridge); ok {
+		br = nb.Bridger
+	}
+
+	if cu, ok := br.(bridge.ConfigUpdater); ok {
+		cu.UpdateConfig(v)
+	}
+}
+
+// getBaseConfig returns the config u started with.
+func (u *User) getBaseConfig() *viper.Viper {
+	u.configMutex.Lock()
+	defer u.configMutex.Unlock()
+
+	return u.baseConfig
+}
+
+// setBaseConfig makes v the config u starts with and uses until it's logged in.
+func (u *User) setBaseConfig(v *viper.Viper) {
+	u.configMutex.Lock()
+	defer u.configMutex.Unlock()
+
+	u.baseConfig = v
+	u.overrides = make(map[string]interface{})
+	u.setConfig(v)
+}
+
+// resetConfig gives u its own copy of its base config, without overrides.
+func (u *User) resetConfig() {
+	u.configMutex.Lock()
+	defer u.configMutex.Unlock()
+
+	u.overrides = make(map[string]interface{})
+	u.setConfig(copyConfig(u.baseConfig))
+}
+
+// updateConfig builds the config of u again, its base config with the overrides on top,
+// and calls change to make changes to it before it replaces the current one.
+func (u *User) updateConfig(change func(c *configChange)) {
+	u.configMutex.Lock()
+	defer u.configMutex.Unlock()
+
+	u.rebuildConfig(change)
+}
+
+// rebaseConfig is updateConfig with base as the new base config.
+func (u *User) rebaseConfig(base *viper.Viper, change func(c *configChange)) {
+	u.configMutex.Lock()
+	defer u.configMutex.Unlock()
+
+	u.baseConfig = base
+	u.rebuildConfig(change)
+}
+
+// rebuildConfig does updateConfig, configMutex must be held.
+func (u *User) rebuildConfig(change func(c *configChange)) {
+	c := &configChange{Viper: copyConfig(u.baseConfig), overrides: u.overrides}
+	for key, value := range u.overrides {
+		c.Viper.Set(key, value)
+	}
+
+	change(c)
+
+	u.setConfig(c.Viper)
+}
+
+// settingsAccount is the key the settings of u are kept under.
+func (u *User) settingsAccount() []byte {
+	return []byte(strings.Join([]string{u.br.Protocol(), strings.ToLower(u.Credentials.Server), u.User}, "|"))
+}
+
+// loadSettings applies the stored settings of u to its config.
+func (u *User) loadSettings() {
+	u.updateConfig(u.applySettings)
+}
+
+// applySettings sets the stored settings of u on c.
+func (u *User) applySettings(c *configChange) {
+	for key, value := range u.storedSettings() {
+		c.Set(u.br.Protocol()+"."+key, value)
+	}
+}
+
+// storedSettings returns the settings of u from the database.
+func (u *User) storedSettings() map[string]interface{} {
+	settings := make(map[string]interface{})
+
+	if u.lastViewedAtDB == nil {
+		return settings
+	}
+
+	err := u.lastViewedAtDB.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(settingsBucket)
+		if b == nil {
+			return nil
+		}
+
+		b = b.Bucket(u.settingsAccount())
+		if b == nil {
+			return nil
+		}
+
+		return b.ForEach(func(k, v []byte) error {
+			var value interface{}
+			if err := json.Unmarshal(v, &value); err != nil {
+				return err
+			}
+
+			if _, ok := settingKinds[string(k)]; ok {
+				settings[string(k)] = value
+			}
+
+			return nil
+		})
+	})
+	if err != nil {
+		logger.Errorf("reading settings of %s failed: %s", u.Nick, err)
+	}
+
+	return settings
+}
+
+// saveSetting stores setting key, a nil value removes it.
+func (u *User) saveSetting(key string, value interface{}) error {
+	if u.lastViewedAtDB == nil {
+		return nil
+	}
+
+	return u.lastViewedAtDB.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(settingsBucket)
+		if err != nil {
+			return err
+		}
+
+		b, err = b.CreateBucketIfNotExists(u.settingsAccount())
+		if err != nil {
+			return err
+		}
+
+		if value == nil {
+			return b.Delete([]byte(key))
+		}
+
+		data, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+
+		return b.Put([]byte(key), data)
+	})
+}
+
+// setSetting changes setting key of u and keeps it, a nil value goes back to the config.
+func (u *User) setSetting(key string, value interface{}) error {
+	if err := u.saveSetting(key, value); err != nil {
+		return err
+	}
+
+	// a nil override falls through to the config again.
+	u.updateConfig(func(c *configChange) {
+		c.Set(u.br.Protocol()+"."+key, value)
+	})
+
+	return nil
+}
+
+// settingValue returns setting key of u as shown to the user.
+func (u *User) settingValue(key string) string {
+	name := u.br.Protocol() + "." + key
+
+	switch settingKinds[key] {
+	case settingBool:
+		return strconv.FormatBool(u.config().GetBool(name))
+	case settingInt:
+		return strconv.Itoa(u.config().GetInt(name))
+	case settingList, settingRegexps:
+		return strings.Join(u.config().GetStringSlice(name), ",")
+	case settingVirtualChannels:
+		return formatVirtualChannels(u.virtualChannels())
+	default:
+		return strconv.Quote(u.config().GetString(name))
+	}
+}
+
+func set(u *User, toUser *User, args []string, service string) {
+	key := strings.ToLower(args[0])
+
+	value, err := parseSetting(key, args[1:])
+	if err != nil {
+		u.MsgUser(toUser, err.Error())
+		return
+	}
+
+	if err := u.setSetting(key, value); err != nil {
+		u.MsgUser(toUser, "saving "+key+" failed: "+err.Error())
+		return
+	}
+
+	u.MsgUser(toUser, key+" = "+u.settingValue(key))
+}
+
+func get(u *User, toUser *User, args []string, service string) {
+	key := strings.ToLower(args[0])
+	if _, ok := settingKinds[key]; !ok {
+		u.MsgUser(toUser, "unknown setting "+key)
+		return
+	}
+
+	_, yours := u.storedSettings()[key]
+	u.MsgUser(toUser, settingLine(key, u.settingValue(key), yours))
+}
+
+func unset(u *User, toUser *User, args []string, service string) {
+	key := strings.ToLower(args[0])
+	if _, ok := settingKinds[key]; !ok {
+		u.MsgUser(toUser, "unknown setting "+key)
+		return
+	}
+
+	if err := u.setSetting(key, nil); err != nil {
+		u.MsgUser(toUser, "removing "+key+" failed: "+err.Error())
+		return
+	}
+
+	u.MsgUser(toUser, key+" = "+u.settingValue(key)+" (from the config)")
+}
+
+func settings(u *User, toUser *User, args []string, service string) {
+	stored := u.storedSettings()
+
+	keys := make([]string, 0, len(settingKinds))
+	for key := range settingKinds {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		_, yours := stored[key]
+		u.MsgUser(toUser, settingLine(key, u.settingValue(key), yours))
+	}
+
+	u.MsgUser(toUser, "change them with SET <setting> <value>, UNSET <setting> goes back to the config")
+}
+
+func settingLine(key, value string, yours bool) string {
+	line := fmt.Sprintf("%s = %s [%s]", key, value, settingKinds[key])
+	if yours {
+		line += " (yours)"
+	}
+
+	return line
+}
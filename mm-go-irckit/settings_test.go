@@ -0,0 +1,78 @@
+package irckit
+
+import (
+	"testing"
+
+	"github.com/42wim/matterircd/bridge"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	bolt "go.etcd.io/bbolt"
+)
+
+// newTestUser returns user id1 logged in to mattermost with its own copy of global.
+func newTestUser(global *viper.Viper, db *bolt.DB) *User {
+	u := newBridgeUser(nil, NewServer("matterircd"), global, db)
+	u.resetConfig()
+	u.User = "id1"
+	u.br = &protocolBridge{protocol: "mattermost"}
+	u.Credentials = bridge.Credentials{Server: "chat.example.com"}
+
+	return u
+}
+
+func TestParseSetting(t *testing.T) {
+	for _, tc := range []struct {
+		key    string
+		values []string
+		want   interface{}
+		err    bool
+	}{
+		{key: "prefixcontext", values: []string{"true"}, want: true},
+		{key: "prefixcontext", values: []string{"yes"}, err: true},
+		{key: "shortenrepliesto", values: []string{"20"}, want: 20},
+		{key: "shortenrepliesto", values: []string{"twenty"}, err: true},
+		{key: "threadcontext", values: []string{"mattermost+post"}, want: "mattermost+post"},
+		{key: "joinexclude", values: []string{"#town-square,#random,", "#off-topic"}, want: []string{"#town-square", "#random", "#off-topic"}},
+		{key: "joinonly", values: []string{"category:Team", "Ops,", "#bugs"}, want: []string{"category:Team Ops", "#bugs"}},
+		{key: "joinexclude", values: []string{""}, want: []string{}},
+		{key: "joinexclude", values: []string{"#(town"}, err: true},
+		{key: "highlightkeywords", values: []string{"deploy(ment)?s?,", "release"}, want: []string{"deploy(ment)?s?", "release"}},
+		{key: "highlightkeywords", values: []string{"release", "candidate"}, want: []string{"release candidate"}},
+		{key: "password", values: []string{"secret"}, err: true},
+	} {
+		got, err := parseSetting(tc.key, tc.values)
+		if tc.err {
+			assert.Error(t, err, tc.key)
+			continue
+		}
+
+		assert.NoError(t, err, tc.key)
+		assert.Equal(t, tc.want, got, tc.key)
+	}
+}
+
+func TestSettings(t *testing.T) {
+	db := newTestDB(t)
+
+	global := viper.New()
+	global.Set("mattermost.prefixcontext", true)
+	global.Set("mattermost.joinexclude", []string{"#random"})
+
+	u := newTestUser(global, db)
+	assert.NoError(t, u.setSetting("prefixcontext", false))
+	assert.NoError(t, u.setSetting("joinexclude", []string{"#town-square"}))
+	assert.False(t, u.v.GetBool("mattermost.prefixcontext"))
+	assert.True(t, global.GetBool("mattermost.prefixcontext"), "the global config is unchanged")
+
+	// the next login gets them back.
+	other := newTestUser(global, db)
+	other.loadSettings()
+	assert.False(t, other.v.GetBool("mattermost.prefixcontext"))
+	assert.Equal(t, []string{"#town-square"}, other.v.GetStringSlice("mattermost.joinexclude"))
+	assert.Equal(t, "#town-square", other.settingValue("joinexclude"))
+
+	assert.NoError(t, u.setSetting("prefixcontext", nil))
+	assert.True(t, u.v.GetBool("mattermost.prefixcontext"), "unset goes back to the config")
+	assert.NotContains(t, u.storedSettings(), "prefixcontext")
+	assert.Contains(t, u.storedSettings(), "joinexclude")
+}
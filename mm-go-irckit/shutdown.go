@@ -0,0 +1,110 @@
+package irckit
+
+import (
+	"sort"
+	"time"
+
+	"github.com/sorcix/irc"
+)
+
+// runningSession is a running user with the number operators know it by.
+type runningSession struct {
+	id      int
+	user    *User
+	started time.Time
+}
+
+// track adds u to the running users until untrack.
+func (m *sessionManager) track(u *User) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.runningID++
+	m.running[u] = runningSession{id: m.runningID, user: u, started: time.Now()}
+	m.runningDone.Add(1)
+}
+
+func (m *sessionManager) untrack(u *User) {
+	m.Lock()
+	defer m.Unlock()
+
+	if _, ok := m.running[u]; ok {
+		delete(m.running, u)
+		m.runningDone.Done()
+	}
+}
+
+func (m *sessionManager) getRunning() []*User {
+	m.Lock()
+	defer m.Unlock()
+
+	users := make([]*User, 0, len(m.running))
+	for u := range m.running {
+		users = append(users, u)
+	}
+
+	return users
+}
+
+// listRunning returns the running users ordered by their number.
+func (m *sessionManager) listRunning() []runningSession {
+	m.Lock()
+	defer m.Unlock()
+
+	list := make([]runningSession, 0, len(m.running))
+	for _, rs := range m.running {
+		list = append(list, rs)
+	}
+
+	sort.Slice(list, func(i, j int) bool { return list[i].id < list[j].id })
+
+	return list
+}
+
+// Shutdown ends all connections: the clients get an ERROR with reason, the messages still
+// in their paste buffer are sent and the bridges are logged out.
+// It waits at most timeout for this to finish.
+func Shutdown(reason string, timeout time.Duration) {
+	users := sessions.getRunning()
+
+	logger.Infof("shutting down %d connections", len(users))
+
+	for _, u := range users {
+		u.shutdown(reason)
+	}
+
+	done := make(chan struct{})
+
+	go func() {
+		sessions.runningDone.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		logger.Errorf("not all connections closed within %s", timeout)
+	}
+}
+
+// shutdown tells the clients of u we're going away and ends the session of u,
+// its handler does the logout once the last messages are handled.
+func (u *User) shutdown(reason string) {
+	sc, ok := u.Conn.(*sessionConn)
+	if !ok {
+		u.Close()
+		return
+	}
+
+	// the bridge is logged out instead of kept running.
+	sc.setPersistent(false)
+
+	for _, client := range sc.getClients() {
+		client.Encode(&irc.Message{ //nolint:errcheck
+			Command:  "ERROR",
+			Trailing: "Closing link: " + reason,
+		})
+	}
+
+	sc.Close()
+}
@@ -0,0 +1,49 @@
+package irckit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShutdown(t *testing.T) {
+	SetLogger(logrus.NewEntry(logrus.New()))
+
+	c, client := newTestClient()
+	sc := newSessionConn(c)
+	sc.setPersistent(true)
+
+	srv := NewServer("matterircd")
+	u := newBridgeUser(sc, srv, viper.New(), nil)
+	u.Nick, u.User = "me", "me"
+	sc.user = u
+
+	go u.Decode()
+
+	handled := make(chan struct{})
+	go func() {
+		srv.(*server).handle(u)
+		close(handled)
+	}()
+
+	// wait for the handler to run.
+	for len(sessions.getRunning()) == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	go Shutdown("going away", time.Second)
+
+	client.expect(t, "ERROR :Closing link: going away")
+
+	select {
+	case <-handled:
+	case <-time.After(time.Second):
+		t.Fatal("session not ended")
+	}
+
+	assert.Empty(t, sessions.getRunning())
+	assert.False(t, sc.isPersistent())
+}
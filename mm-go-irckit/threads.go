@@ -0,0 +1,239 @@
+package irckit
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/42wim/matterircd/bridge"
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/sorcix/irc"
+)
+
+// Threads can be joined as channels: /JOIN <post ID>, @@<post ID> or a permalink gives
+// #channel/t-<root ID> with the thread so far and all its replies. A channel named like the
+// post ID is joined instead, @@<post ID> and permalinks are always threads.
+// Messages there are replies in the thread, parting only leaves it on IRC.
+
+const threadChannelPrefix = "thread/"
+
+var (
+	threadIDRegExp        = regexp.MustCompile(`^(?:#|@@)?([0-9a-z]{26})$`)
+	threadPermalinkRegExp = regexp.MustCompile(`/pl/([0-9a-z]{26})/?$`)
+)
+
+// threadChannel is a thread we joined as channel.
+type threadChannel struct {
+	ch Channel
+	// channelID is the channel the thread is in.
+	channelID string
+}
+
+// threadPostID returns the post ID in name (a post ID or permalink), "" if there's none.
+func threadPostID(name string) string {
+	if matches := threadIDRegExp.FindStringSubmatch(name); matches != nil {
+		return matches[1]
+	}
+
+	if matches := threadPermalinkRegExp.FindStringSubmatch(name); matches != nil {
+		return matches[1]
+	}
+
+	return ""
+}
+
+// isChannelName tells if name, a post ID as #<id> or <id>, is the name of a channel: channels
+// get their ID as name when their display name can't be made into one (eg non-Latin names).
+func (u *User) isChannelName(name string) bool {
+	if strings.HasPrefix(name, "@@") || threadPermalinkRegExp.MatchString(name) {
+		return false
+	}
+
+	return u.br.GetChannelID(strings.TrimPrefix(name, "#"), "") != ""
+}
+
+// threadOf returns the thread channel ch is, ok is false if it isn't one.
+func (u *User) threadOf(ch Channel) (string, threadChannel, bool) {
+	if !strings.HasPrefix(ch.ID(), threadChannelPrefix) {
+		return "", threadChannel{}, false
+	}
+
+	rootID := strings.TrimPrefix(ch.ID(), threadChannelPrefix)
+	tc, ok := u.threadChannel(rootID)
+
+	return rootID, tc, ok
+}
+
+// threadChannel returns the channel of thread rootID, if we joined it.
+func (u *User) threadChannel(rootID string) (threadChannel, bool) {
+	u.threadsMutex.RLock()
+	defer u.threadsMutex.RUnlock()
+
+	tc, ok := u.threads[rootID]
+
+	return tc, ok
+}
+
+// joinThread joins the thread of post postID as a channel and shows it (mattermost only).
+func (u *User) joinThread(postID string) error {
+	postlist, _ := u.br.GetPostThread(postID).(*model.PostList)
+	if postlist == nil || postlist.Posts[postID] == nil {
+		return errors.New("no such thread")
+	}
+
+	rootID := postlist.Posts[postID].RootId
+	if rootID == "" {
+		rootID = postID
+	}
+
+	root, ok := postlist.Posts[rootID]
+	if !ok {
+		return errors.New("no such thread")
+	}
+
+	if tc, ok := u.threadChannel(rootID); ok && tc.ch.HasUser(u) {
+		return nil
+	}
+
+	name := u.Srv.Channel(root.ChannelId).String() + "/t-" + rootID[:8]
+	ch := u.Srv.NamedChannel(threadChannelPrefix+rootID, name)
+
+	u.threadsMutex.Lock()
+	u.threads[rootID] = threadChannel{ch: ch, channelID: root.ChannelId}
+	u.threadsMutex.Unlock()
+
+	posts := make([]*model.Post, 0, len(postlist.Posts))
+	for _, p := range postlist.Posts {
+		if p.DeleteAt <= p.CreateAt {
+			posts = append(posts, p)
+		}
+	}
+
+	sort.Slice(posts, func(i, j int) bool { return posts[i].CreateAt < posts[j].CreateAt })
+
+	var participants []*bridge.UserInfo
+
+	seen := make(map[string]bool)
+
+	for _, p := range posts {
+		if user := u.br.GetUser(p.UserId); user != nil && !user.Me && !seen[p.UserId] {
+			participants = append(participants, user)
+			seen[p.UserId] = true
+		}
+	}
+
+	ghosts := u.CreateUsersFromInfo(participants)
+	u.Srv.BatchAdd(ghosts)
+	ch.BatchJoin(ghosts) //nolint:errcheck
+
+	ch.Join(u) //nolint:errcheck
+
+	topic := strings.SplitN(root.Message, "\n", 2)[0]
+	if user := u.br.GetUser(root.UserId); user != nil {
+		topic = "thread of " + u.ghostNick(user) + ": " + topic
+	}
+
+	svc, _ := u.Srv.HasUser(u.br.Protocol())
+	ch.Topic(svc, topic)
+
+	for _, p := range posts {
+		u.showThreadPost(ch, rootID, p)
+	}
+
+	return nil
+}
+
+// showThreadPost shows post p of thread rootID in ch.
+func (u *User) showThreadPost(ch Channel, rootID string, p *model.Post) {
+	user := u.br.GetUser(p.UserId)
+	if user == nil {
+		return
+	}
+
+	nick := u.ghostNick(user)
+	if user.Me {
+		nick = u.Nick
+	}
+
+	parentID := p.RootId
+	if p.Id == rootID {
+		parentID = ""
+	}
+
+	ts := time.Unix(0, p.CreateAt*int64(time.Millisecond)).Format("15:04")
+
+	for _, line := range strings.Split(p.Message, "\n") {
+		if line == "" {
+			continue
+		}
+
+		msg := fmt.Sprintf("[%s] %s", ts, line)
+		if u.config().GetBool(u.br.Protocol()+".prefixcontext") || u.config().GetBool(u.br.Protocol()+".suffixcontext") {
+			threadMsgID := u.prefixContext(ch.ID(), p.Id, parentID, "thread")
+			msg = u.formatContextMessage(ts, threadMsgID, line)
+		}
+
+		ch.SpoofMessage(nick, msg)
+	}
+}
+
+// mirrorThread shows the message of event from nick in its thread, if we joined it.
+func (u *User) mirrorThread(nick string, event *bridge.ChannelMessageEvent) {
+	if event.ParentID == "" {
+		return
+	}
+
+	tc, ok := u.threadChannel(event.ParentID)
+	if !ok || !tc.ch.HasUser(u) {
+		return
+	}
+
+	if !event.Sender.Me {
+		ghost := u.createUserFromInfo(event.Sender)
+		if !tc.ch.HasUser(ghost) {
+			tc.ch.Join(ghost) //nolint:errcheck
+		}
+	}
+
+	u.spoofChannelMessage(tc.ch, nick, tc.ch.ID(), event)
+}
+
+// msgThread sends msg, said in the channel of thread rootID, as a reply in the thread.
+func (u *User) msgThread(rootID string, tc threadChannel, msg *irc.Message) error {
+	msgID, err := u.br.MsgChannelThread(tc.channelID, rootID, msg.Trailing)
+	if err != nil {
+		u.MsgSpoofUser(u, u.br.Protocol(), "msg: "+msg.Trailing+" could not be sent "+err.Error())
+		return err
+	}
+
+	u.threadReplied(rootID)
+
+	u.msgLastMutex.Lock()
+	defer u.msgLastMutex.Unlock()
+	u.msgLast[tc.ch.ID()] = [2]string{msgID, rootID}
+	u.saveLastViewedAt(tc.channelID)
+
+	if u.config().GetBool(u.br.Protocol()+".prefixcontext") || u.config().GetBool(u.br.Protocol()+".suffixcontext") {
+		u.prefixContext(tc.ch.ID(), msgID, rootID, "posted_self")
+	}
+
+	return nil
+}
+
+// partThread leaves thread channel ch on IRC only.
+func (u *User) partThread(rootID string, ch Channel, text string) {
+	ch.Part(u, text)
+
+	for _, other := range ch.Users() {
+		ch.Part(other, "")
+	}
+
+	u.threadsMutex.Lock()
+	delete(u.threads, rootID)
+	u.threadsMutex.Unlock()
+
+	ch.Unlink()
+}
@@ -0,0 +1,52 @@
+package irckit
+
+import (
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestThreadPostID(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		want string
+	}{
+		{"cfrakpwix7y8pgzux6ta76pm9c", "cfrakpwix7y8pgzux6ta76pm9c"},
+		{"#cfrakpwix7y8pgzux6ta76pm9c", "cfrakpwix7y8pgzux6ta76pm9c"},
+		{"@@cfrakpwix7y8pgzux6ta76pm9c", "cfrakpwix7y8pgzux6ta76pm9c"},
+		{"https://chat.example.com/team/pl/cfrakpwix7y8pgzux6ta76pm9c", "cfrakpwix7y8pgzux6ta76pm9c"},
+		{"#town-square", ""},
+		{"#cfrakpwix7y8pgzux6ta76pm9", ""},
+		{"https://chat.example.com/team/channels/town-square", ""},
+	} {
+		assert.Equal(t, tc.want, threadPostID(tc.name), tc.name)
+	}
+}
+
+type channelIDBridge struct {
+	protocolBridge
+	channels map[string]string
+}
+
+func (b *channelIDBridge) GetChannelID(name, teamID string) string {
+	return b.channels[name]
+}
+
+func TestIsChannelName(t *testing.T) {
+	u := newBridgeUser(nil, nil, viper.New(), nil)
+	u.br = &channelIDBridge{protocolBridge{protocol: "mattermost"}, map[string]string{"xq8ofjd1tbgh7mkrwnaycs53ie": "channelid"}}
+
+	for _, tc := range []struct {
+		name string
+		want bool
+	}{
+		{"#xq8ofjd1tbgh7mkrwnaycs53ie", true},
+		{"xq8ofjd1tbgh7mkrwnaycs53ie", true},
+		{"@@xq8ofjd1tbgh7mkrwnaycs53ie", false},
+		{"https://chat.example.com/team/pl/xq8ofjd1tbgh7mkrwnaycs53ie", false},
+		{"#cfrakpwix7y8pgzux6ta76pm9c", false},
+	} {
+		assert.Equal(t, tc.want, u.isChannelName(tc.name), tc.name)
+	}
+}
@@ -0,0 +1,152 @@
+package irckit
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/42wim/matterircd/bridge"
+)
+
+// replayUnread is the logSince of replaying the unread posts of a channel, those are
+// replayed even when we've shown them already (eg with disableautoview).
+const replayUnread = "unread"
+
+// unreadChannel is a channel with what we haven't read of it.
+type unreadChannel struct {
+	name string
+	*bridge.UnreadInfo
+}
+
+// sortUnread sorts channels by mentions, then by messages and name.
+func sortUnread(channels []unreadChannel) {
+	sort.Slice(channels, func(i, j int) bool {
+		a, b := channels[i], channels[j]
+
+		switch {
+		case a.Mentions != b.Mentions:
+			return a.Mentions > b.Mentions
+		case a.Messages != b.Messages:
+			return a.Messages > b.Messages
+		default:
+			return a.name < b.name
+		}
+	})
+}
+
+// unreadKeeper returns the bridge of u if it knows what we haven't read.
+func (u *User) unreadKeeper() (bridge.UnreadKeeper, bool) {
+	keeper, ok := u.br.(bridge.UnreadKeeper)
+
+	return keeper, ok
+}
+
+// channelDisplayName returns the name of channelID as shown on IRC, the nick for DMs.
+func (u *User) channelDisplayName(channelID string) string {
+	brchannel, err := u.br.GetChannel(channelID)
+	if err != nil {
+		return channelID
+	}
+
+	if strings.Contains(brchannel.Name, "__") {
+		for _, userID := range strings.Split(brchannel.Name, "__") {
+			if user := u.br.GetUser(userID); user != nil && !user.Me {
+				return u.ghostNick(user)
+			}
+		}
+	}
+
+	if ch, ok := u.Srv.HasChannel(channelID); ok {
+		return ch.String()
+	}
+
+	return "#" + brchannel.Name
+}
+
+func unread(u *User, toUser *User, args []string, service string) {
+	if len(args) == 1 {
+		replayUnreadChannel(u, toUser, args[0])
+		return
+	}
+
+	keeper, ok := u.unreadKeeper()
+	if !ok {
+		u.MsgUser(toUser, "unread is not supported on "+service)
+		return
+	}
+
+	list, err := keeper.GetUnread()
+	if err != nil {
+		u.MsgUser(toUser, "getting unread channels failed: "+err.Error())
+		return
+	}
+
+	if len(list) == 0 {
+		u.MsgUser(toUser, "nothing unread")
+		return
+	}
+
+	channels := make([]unreadChannel, 0, len(list))
+	for _, info := range list {
+		channels = append(channels, unreadChannel{u.channelDisplayName(info.ChannelID), info})
+	}
+
+	sortUnread(channels)
+
+	for _, c := range channels {
+		u.MsgUser(toUser, fmt.Sprintf("%s: %d unread, %d mentions", c.name, c.Messages, c.Mentions))
+	}
+
+	u.MsgUser(toUser, "replay a channel with UNREAD #<channel>, MARKREAD ALL marks everything as read")
+}
+
+// replayUnreadChannel replays the posts of channel name we haven't read (on the server).
+func replayUnreadChannel(u *User, toUser *User, name string) {
+	if !strings.HasPrefix(name, "#") {
+		u.MsgUser(toUser, "need "+commandUsage("unread", u.br.Protocol()))
+		return
+	}
+
+	channelID := u.br.GetChannelID(strings.TrimPrefix(name, "#"), u.br.GetMe().TeamID)
+
+	brchannel, err := u.br.GetChannel(channelID)
+	if channelID == "" || err != nil {
+		u.MsgUser(toUser, "channel "+name+" does not exist")
+		return
+	}
+
+	since := u.br.GetLastViewedAt(channelID)
+	if since == 0 {
+		u.MsgUser(toUser, "no unread messages in "+name)
+		return
+	}
+
+	if u.replayPosts(brchannel, u.createSpoof(brchannel), since, replayUnread) == 0 {
+		u.MsgUser(toUser, "no unread messages in "+name)
+	}
+}
+
+func markread(u *User, toUser *User, args []string, service string) {
+	if !strings.EqualFold(args[0], "all") {
+		updatelastviewed(u, toUser, args, service)
+		return
+	}
+
+	keeper, ok := u.unreadKeeper()
+	if !ok {
+		u.MsgUser(toUser, "markread all is not supported on "+service)
+		return
+	}
+
+	marked, err := keeper.MarkAllRead()
+	for _, channelID := range marked {
+		u.saveLastViewedAt(channelID)
+	}
+
+	if err != nil {
+		u.MsgUser(toUser, fmt.Sprintf("marking all as read failed after %d channels: %s", len(marked), err))
+		return
+	}
+
+	u.MsgUser(toUser, fmt.Sprintf("marked %d channels and all threads as read", len(marked)))
+}
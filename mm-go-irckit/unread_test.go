@@ -0,0 +1,27 @@
+package irckit
+
+import (
+	"testing"
+
+	"github.com/42wim/matterircd/bridge"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSortUnread(t *testing.T) {
+	channels := []unreadChannel{
+		{"#town-square", &bridge.UnreadInfo{Messages: 40}},
+		{"#bugs", &bridge.UnreadInfo{Messages: 3, Mentions: 1}},
+		{"alice", &bridge.UnreadInfo{Messages: 2, Mentions: 2}},
+		{"#ops", &bridge.UnreadInfo{Messages: 3, Mentions: 1}},
+		{"#dev", &bridge.UnreadInfo{Messages: 5, Mentions: 1}},
+	}
+
+	sortUnread(channels)
+
+	names := make([]string, 0, len(channels))
+	for _, c := range channels {
+		names = append(names, c.name)
+	}
+
+	assert.Equal(t, []string{"alice", "#dev", "#bugs", "#ops", "#town-square"}, names)
+}
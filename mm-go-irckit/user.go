@@ -48,7 +48,9 @@ type User struct {
 
 	channels map[Channel]struct{}
 
-	v *viper.Viper
+	// v is replaced instead of changed, read it with config().
+	v      *viper.Viper
+	vMutex sync.RWMutex
 
 	UserBridge
 }
@@ -66,6 +68,12 @@ func (u *User) Prefix() *irc.Prefix {
 	}
 }
 
+// isIntegration returns true for the ghosts of webhooks and bots posting under
+// their own username, these don't exist on the server.
+func (u *User) isIntegration() bool {
+	return u.Ghost && strings.Contains(u.User, "/")
+}
+
 func (u *User) Close() error {
 	for ch := range u.channels {
 		ch.Part(u, defaultCloseMsg)
@@ -168,7 +176,7 @@ func (u *User) Decode() {
 	}
 	buffer := make(chan *irc.Message)
 	stop := make(chan struct{})
-	bufferTimeout := u.v.GetInt("PasteBufferTimeout")
+	bufferTimeout := u.config().GetInt("PasteBufferTimeout")
 	// we need at least 100
 	if bufferTimeout < 100 {
 		bufferTimeout = 100
@@ -176,7 +184,9 @@ func (u *User) Decode() {
 	logger.Debugf("using paste buffer timeout: %#v", bufferTimeout)
 	t := timer.NewTimer(time.Duration(bufferTimeout) * time.Millisecond)
 	t.Stop()
+	done := make(chan struct{})
 	go func(buffer chan *irc.Message, stop chan struct{}) {
+		defer close(done)
 		for {
 			select {
 			case msg := <-buffer:
@@ -214,6 +224,13 @@ func (u *User) Decode() {
 					t.Stop()
 				}
 			case <-stop:
+				// don't lose what's still in the buffer.
+				if u.BufferedMsg != nil {
+					u.BufferedMsg.Trailing = strings.TrimSpace(u.BufferedMsg.Trailing)
+					logger.Debugf("flushing buffer on close: %#v", u.BufferedMsg)
+					u.DecodeCh <- u.BufferedMsg
+					u.BufferedMsg = nil
+				}
 				logger.Debug("closing decode()")
 				return
 			}
@@ -250,6 +267,10 @@ func (u *User) Decode() {
 			u.DecodeCh <- msg
 		}
 	}
+
+	// wait for the buffer to stop before telling the server we're done.
+	<-done
+	close(u.DecodeCh)
 }
 
 func (u *User) createService(nick string, what string) {
@@ -1,15 +1,14 @@
 package irckit
 
 import (
-	"bytes"
 	"encoding/binary"
 	"fmt"
 	"math/rand"
 	"net"
 	"net/http"
-	"regexp"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	bolt "go.etcd.io/bbolt"
@@ -18,7 +17,6 @@ import (
 	"github.com/42wim/matterircd/bridge/mastodon"
 	"github.com/42wim/matterircd/bridge/mattermost"
 	"github.com/42wim/matterircd/bridge/slack"
-	"github.com/alecthomas/chroma/v2/quick"
 	"github.com/davecgh/go-spew/spew"
 	"github.com/mattermost/mattermost-server/v6/model"
 	"github.com/muesli/reflow/wordwrap"
@@ -36,8 +34,20 @@ type UserBridge struct {
 	eventChan   chan *bridge.Event //nolint:structcheck
 	away        bool               //nolint:structcheck
 
+	// for the queues admin command.
+	eventStarted  atomic.Int64  // unix nano time the event being handled came in, 0 when idle
+	eventsHandled atomic.Uint64 //nolint:structcheck
+
 	lastViewedAtDB *bolt.DB //nolint:structcheck
 
+	nickMutex sync.Mutex //nolint:structcheck
+
+	network         string           //nolint:structcheck
+	parent          *User            //nolint:structcheck
+	networks        map[string]*User //nolint:structcheck
+	channelNetworks map[string]*User //nolint:structcheck
+	networksMutex   sync.RWMutex     //nolint:structcheck
+
 	msgCounterMutex sync.RWMutex   //nolint:structcheck
 	msgCounter      map[string]int //nolint:structcheck
 
@@ -51,40 +61,98 @@ type UserBridge struct {
 
 	updateCounterMutex sync.Mutex           //nolint:structcheck
 	updateCounter      map[string]time.Time //nolint:structcheck
+
+	seenPostsMutex sync.Mutex          //nolint:structcheck
+	seenPosts      map[string]struct{} //nolint:structcheck
+	seenPostsOrder []string            //nolint:structcheck
+	lastEventAt    time.Time           //nolint:structcheck
+
+	oper string //nolint:structcheck
+
+	virtualMutex        sync.Mutex        //nolint:structcheck
+	virtualJoined       map[string]bool   //nolint:structcheck
+	virtualSources      map[string]string //nolint:structcheck
+	virtualSourcesOrder []string          //nolint:structcheck
+
+	threadsMutex sync.RWMutex             //nolint:structcheck
+	threads      map[string]threadChannel //nolint:structcheck
+
+	// followed are the threads we follow, nil until fetched.
+	followedMutex   sync.Mutex      //nolint:structcheck
+	followed        map[string]bool //nolint:structcheck
+	followedLoading int32           //nolint:structcheck
+
+	// channelNotify are our notification preferences of our channels by channel ID.
+	notifyMutex   sync.RWMutex                     //nolint:structcheck
+	channelNotify map[string]*bridge.ChannelNotify //nolint:structcheck
+
+	// joinedChannels are the channels joined by hand, kept across config reloads.
+	joinedMutex    sync.Mutex      //nolint:structcheck
+	joinedChannels map[string]bool //nolint:structcheck
+
+	// categories are our categories of channels, in their order.
+	categoriesMutex sync.RWMutex           //nolint:structcheck
+	categories      []*bridge.CategoryInfo //nolint:structcheck
+
+	// baseConfig is the config we started with, v is a copy of it with our settings once logged in.
+	baseConfig  *viper.Viper           //nolint:structcheck
+	overrides   map[string]interface{} //nolint:structcheck
+	configMutex sync.Mutex
+	profile     string //nolint:structcheck
 }
 
 func NewUserBridge(c net.Conn, srv Server, cfg *viper.Viper, db *bolt.DB) *User {
-	u := NewUser(&conn{
+	sc := newSessionConn(&conn{
 		Conn:    c,
 		Encoder: irc.NewEncoder(c),
 		Decoder: irc.NewDecoder(c),
 	})
+	u := newBridgeUser(sc, srv, cfg, db)
+	sc.user = u
+
+	// used for login
+	u.createService("mattermost", "loginservice")
+	u.createService("slack", "loginservice")
+	u.createService("mastodon", "loginservice")
+	u.createService("matterircd", "systemservice")
+	return u
+}
 
+func newBridgeUser(c Conn, srv Server, cfg *viper.Viper, db *bolt.DB) *User {
+	u := NewUser(c)
 	u.Srv = srv
 	u.v = cfg
+	u.baseConfig = cfg
+	u.overrides = make(map[string]interface{})
 	u.lastViewedAtDB = db
 	u.msgLast = make(map[string][2]string)
 	u.msgMap = make(map[string]map[string]int)
 	u.msgMapIndex = make(map[string]map[int]string)
 	u.msgCounter = make(map[string]int)
 	u.updateCounter = make(map[string]time.Time)
+	u.seenPosts = make(map[string]struct{})
+	u.virtualJoined = make(map[string]bool)
+	u.virtualSources = make(map[string]string)
+	u.threads = make(map[string]threadChannel)
+	u.joinedChannels = make(map[string]bool)
 	u.eventChan = make(chan *bridge.Event, 1000)
+	u.networks = make(map[string]*User)
+	u.channelNetworks = make(map[string]*User)
 
-	// used for login
-	u.createService("mattermost", "loginservice")
-	u.createService("slack", "loginservice")
-	u.createService("mastodon", "loginservice")
-	u.createService("matterircd", "systemservice")
 	return u
 }
 
 func (u *User) handleEventChan() {
 	for event := range u.eventChan {
+		u.eventStarted.Store(time.Now().UnixNano())
 		logger.Tracef("eventchan %s", spew.Sdump(event))
+		u.claimChannel(eventChannelID(event))
 		switch e := event.Data.(type) {
 		case *bridge.ChannelMessageEvent:
+			u.postReceived(e.MessageID)
 			u.handleChannelMessageEvent(e)
 		case *bridge.DirectMessageEvent:
+			u.postReceived(e.MessageID)
 			u.handleDirectMessageEvent(e)
 		case *bridge.ChannelTopicEvent:
 			u.handleChannelTopicEvent(e)
@@ -104,9 +172,22 @@ func (u *User) handleEventChan() {
 			u.handleStatusChangeEvent(e)
 		case *bridge.ReactionAddEvent, *bridge.ReactionRemoveEvent:
 			u.handleReactionEvent(e)
+		case *bridge.ThreadFollowEvent:
+			u.handleThreadFollowEvent(e)
+		case *bridge.ChannelNotifyEvent:
+			u.handleChannelNotifyEvent(e)
+		case *bridge.CategoriesEvent:
+			u.handleCategoriesEvent()
+		case *bridge.DisconnectEvent:
+			u.handleDisconnectEvent()
+		case *bridge.ReconnectEvent:
+			u.handleReconnectEvent()
 		case *bridge.LogoutEvent:
 			return
 		}
+
+		u.eventStarted.Store(0)
+		u.eventsHandled.Add(1)
 	}
 }
 
@@ -129,7 +210,20 @@ func (u *User) handleChannelTopicEvent(event *bridge.ChannelTopicEvent) {
 }
 
 func (u *User) handleDirectMessageEvent(event *bridge.DirectMessageEvent) {
-	if u.v.GetBool(u.br.Protocol() + ".showmentions") {
+	if event.Sender.Bot && !event.Sender.Me && u.config().GetBool(u.br.Protocol()+".hidebots") {
+		logger.Debugf("Not showing bot message from %s", event.Sender.Nick)
+		u.saveLastViewedAt(event.ChannelID)
+		return
+	}
+
+	prefixUser := event.Sender.User
+	if event.Sender.Me {
+		prefixUser = event.Receiver.User
+	} else {
+		u.highlight(u.createUserFromInfo(event.Sender).Nick, prefixUser, event.MessageID, event.ParentID, "", event.Text)
+	}
+
+	if u.config().GetBool(u.br.Protocol() + ".showmentions") {
 		for _, m := range u.MentionKeys {
 			if m == u.Nick {
 				continue
@@ -145,30 +239,15 @@ func (u *User) handleDirectMessageEvent(event *bridge.DirectMessageEvent) {
 		}
 	}
 
-	prefixUser := event.Sender.User
-	if event.Sender.Me {
-		prefixUser = event.Receiver.User
-	}
 	text, prefix, suffix, showContext, maxlen := u.handleMessageThreadContext(prefixUser, event.MessageID, event.ParentID, event.Event, event.Text)
 
-	lexer := ""
-	codeBlockBackTick := false
-	codeBlockTilde := false
 	text = wordwrap.String(text, maxlen)
 	lines := strings.Split(text, "\n")
 	for _, text := range lines {
-
-		// TODO: Ideally, we want to read the whole code block and syntax highlight on that, but let's go with per-line for now.
-		text, codeBlockBackTick, codeBlockTilde, lexer = u.formatCodeBlockText(text, prefix, codeBlockBackTick, codeBlockTilde, lexer)
-
 		if text == "" {
 			continue
 		}
 
-		if !u.v.GetBool(u.br.Protocol()+".disableircemphasis") && !codeBlockBackTick && !codeBlockTilde {
-			text = markdown2irc(text)
-		}
-
 		if showContext {
 			text = prefix + text + suffix
 		}
@@ -179,12 +258,14 @@ func (u *User) handleDirectMessageEvent(event *bridge.DirectMessageEvent) {
 			} else {
 				u.MsgSpoofUser(u, event.Receiver.Nick, text, len(text))
 			}
+		} else if botch := u.botChannel(event.Sender); botch != nil {
+			botch.SpoofMessage(u.createUserFromInfo(event.Sender).Nick, text, len(text))
 		} else {
 			u.MsgSpoofUser(u.createUserFromInfo(event.Sender), u.Nick, text, len(text))
 		}
 	}
 
-	if !u.v.GetBool(u.br.Protocol() + ".disableautoview") {
+	if !u.config().GetBool(u.br.Protocol() + ".disableautoview") {
 		u.updateLastViewed(event.ChannelID)
 	}
 	u.saveLastViewedAt(event.ChannelID)
@@ -208,7 +289,7 @@ func (u *User) handleChannelAddEvent(event *bridge.ChannelAddEvent) {
 		}
 	}
 
-	if !u.v.GetBool(u.br.Protocol() + ".disableautoview") {
+	if !u.config().GetBool(u.br.Protocol() + ".disableautoview") {
 		u.updateLastViewed(event.ChannelID)
 	}
 	u.saveLastViewedAt(event.ChannelID)
@@ -269,21 +350,32 @@ func (u *User) handleChannelMessageEvent(event *bridge.ChannelMessageEvent) {
 		CHANNEL_DIRECT                 = "D"
 		CHANNEL_GROUP                  = "G"
 	*/
-	nick := sanitizeNick(event.Sender.Nick)
+	nick := u.ghostNick(event.Sender)
 	logger.Debug("in handleChannelMessageEvent")
 	ch := u.getMessageChannel(event.ChannelID, event.Sender)
 	if event.Sender.Me {
 		nick = u.Nick
 	}
 
-	if event.ChannelType != "D" && ch.ID() == "&messages" {
-		if u.v.GetBool(u.br.Protocol() + ".showonlyjoined") {
-			return
-		}
-		nick += "/" + u.Srv.Channel(event.ChannelID).String()
+	if event.Sender.Bot && !event.Sender.Me && u.config().GetBool(u.br.Protocol()+".hidebots") {
+		logger.Debugf("Not showing bot message from %s", nick)
+		u.saveLastViewedAt(event.ChannelID)
+		return
 	}
 
-	if u.v.GetBool(u.br.Protocol() + ".showmentions") {
+	muted := u.mutedMode(event.ChannelID)
+
+	if !event.Sender.Me && muted != mutedMessages {
+		u.highlight(nick+"/"+u.Srv.Channel(event.ChannelID).String(), event.ChannelID, event.MessageID, event.ParentID, "", event.Text)
+	}
+
+	if muted == mutedExclude {
+		logger.Debugf("Not showing message of muted channel %s", event.ChannelID)
+		u.saveLastViewedAt(event.ChannelID)
+		return
+	}
+
+	if u.config().GetBool(u.br.Protocol()+".showmentions") && u.showMentionsIn(event.ChannelID) {
 		for _, m := range u.MentionKeys {
 			if m == u.Nick {
 				continue
@@ -299,35 +391,64 @@ func (u *User) handleChannelMessageEvent(event *bridge.ChannelMessageEvent) {
 		}
 	}
 
+	u.mirrorThread(nick, event)
+
+	if event.ParentID != "" && event.ChannelType != "D" && u.config().GetBool(u.br.Protocol()+".threadsonly") &&
+		u.showFollowedReply(nick, event) {
+		u.saveLastViewedAt(event.ChannelID)
+		return
+	}
+
+	if u.mirrorVirtual(nick, event) && u.config().GetBool(u.br.Protocol()+".virtualchannelsmove") {
+		if !u.config().GetBool(u.br.Protocol() + ".disableautoview") {
+			u.updateLastViewed(event.ChannelID)
+		}
+		u.saveLastViewedAt(event.ChannelID)
+		return
+	}
+
+	if event.ChannelType != "D" && ch.ID() == "&messages" {
+		if u.config().GetBool(u.br.Protocol() + ".showonlyjoined") {
+			return
+		}
+		nick += "/" + u.Srv.Channel(event.ChannelID).String()
+	}
+
+	if botch := u.botChannel(event.Sender); botch != nil {
+		if ch.ID() != "&messages" {
+			nick += "/" + ch.String()
+		}
+		ch = botch
+	}
+
+	u.spoofChannelMessage(ch, nick, event.ChannelID, event)
+
+	if !u.config().GetBool(u.br.Protocol() + ".disableautoview") {
+		u.updateLastViewed(event.ChannelID)
+	}
+	u.saveLastViewedAt(event.ChannelID)
+}
+
+// spoofChannelMessage shows the message of event from nick in ch, its context is counted in contextID.
+func (u *User) spoofChannelMessage(ch Channel, nick, contextID string, event *bridge.ChannelMessageEvent) {
 	text := event.Text
 	prefix := ""
 	suffix := ""
 	showContext := false
 	maxlen := 440
 	if u.Nick != systemUser {
-		text, prefix, suffix, showContext, maxlen = u.handleMessageThreadContext(event.ChannelID, event.MessageID, event.ParentID, event.Event, event.Text)
+		text, prefix, suffix, showContext, maxlen = u.handleMessageThreadContext(contextID, event.MessageID, event.ParentID, event.Event, event.Text)
 	} else {
-		text = "\x1d" + text + "\x1d"
+		text = "\x1d" + u.formatMarkdown(text, maxlen) + "\x1d"
 	}
 
-	lexer := ""
-	codeBlockBackTick := false
-	codeBlockTilde := false
 	text = wordwrap.String(text, maxlen)
 	lines := strings.Split(text, "\n")
 	for _, text := range lines {
-
-		// TODO: Ideally, we want to read the whole code block and syntax highlight on that, but let's go with per-line for now.
-		text, codeBlockBackTick, codeBlockTilde, lexer = u.formatCodeBlockText(text, prefix, codeBlockBackTick, codeBlockTilde, lexer)
-
 		if text == "" {
 			continue
 		}
 
-		if !u.v.GetBool(u.br.Protocol()+".disableircemphasis") && !codeBlockBackTick && !codeBlockTilde {
-			text = markdown2irc(text)
-		}
-
 		if showContext {
 			text = prefix + text + suffix
 		}
@@ -339,15 +460,10 @@ func (u *User) handleChannelMessageEvent(event *bridge.ChannelMessageEvent) {
 			ch.SpoofMessage(nick, text, len(text))
 		}
 	}
-
-	if !u.v.GetBool(u.br.Protocol() + ".disableautoview") {
-		u.updateLastViewed(event.ChannelID)
-	}
-	u.saveLastViewedAt(event.ChannelID)
 }
 
 func (u *User) handleFileEvent(event *bridge.FileEvent) {
-	if u.v.GetBool(u.br.Protocol()+".showonlyjoined") && event.ChannelType != "D" {
+	if u.config().GetBool(u.br.Protocol()+".showonlyjoined") && event.ChannelType != "D" {
 		ch := u.getMessageChannel(event.ChannelID, event.Sender)
 		if ch.ID() == "&messages" {
 			return
@@ -356,7 +472,7 @@ func (u *User) handleFileEvent(event *bridge.FileEvent) {
 
 	for _, fname := range event.Files {
 		fileMsg := "\x1ddownload file - " + fname.Name + "\x1d"
-		if u.v.GetBool(u.br.Protocol()+".prefixcontext") || u.v.GetBool(u.br.Protocol()+".suffixcontext") {
+		if u.config().GetBool(u.br.Protocol()+".prefixcontext") || u.config().GetBool(u.br.Protocol()+".suffixcontext") {
 			threadMsgID := u.prefixContext(event.ChannelID, event.MessageID, event.ParentID, "posted_file")
 			fileMsg = u.formatContextMessage("", threadMsgID, fileMsg)
 		}
@@ -463,11 +579,17 @@ func (u *User) handleReactionEvent(event interface{}) {
 
 	defer u.saveLastViewedAt(channelID)
 
-	if u.v.GetBool(u.br.Protocol() + ".hidereactions") {
+	if u.config().GetBool(u.br.Protocol() + ".hidereactions") {
 		logger.Debug("Not showing reaction: " + text + reaction)
 		return
 	}
 
+	if u.config().GetBool(u.br.Protocol() + ".unicode") {
+		if e, ok := emojiToUnicode(reaction); ok {
+			reaction = e
+		}
+	}
+
 	if channelType == "D" {
 		e := &bridge.DirectMessageEvent{
 			Text:      "\x1d" + text + reaction + "\x1d" + message,
@@ -503,15 +625,19 @@ func (u *User) CreateUserFromInfo(info *bridge.UserInfo) *User {
 func (u *User) CreateUsersFromInfo(info []*bridge.UserInfo) []*User {
 	var users []*User
 
+	u.nickMutex.Lock()
+	defer u.nickMutex.Unlock()
+
+	// nicks used in this batch, they aren't added to the server yet.
+	taken := make(map[string]string)
+
 	for _, userinfo := range info {
 		if userinfo.Me {
 			continue
 		}
 
-		userinfo := userinfo
-		ghost := NewUser(u.Conn)
-		ghost.UserInfo = userinfo
-		ghost.Nick = sanitizeNick(ghost.Nick)
+		ghost := u.newGhost(userinfo, taken)
+		taken[strings.ToLower(ghost.Nick)] = userinfo.User
 		users = append(users, ghost)
 	}
 
@@ -519,23 +645,35 @@ func (u *User) CreateUsersFromInfo(info []*bridge.UserInfo) []*User {
 }
 
 func (u *User) updateUserFromInfo(info *bridge.UserInfo) *User {
+	u.nickMutex.Lock()
+	defer u.nickMutex.Unlock()
+
 	if ghost, ok := u.Srv.HasUserID(info.User); ok {
-		if ghost.Nick != info.Nick {
+		nick := ghost.Nick
+		if sanitizeNick(u.botNick(info)) != strings.TrimSuffix(ghost.Nick, nickSuffix(info.User)) {
+			nick = u.resolveNick(info.User, u.botNick(info), nil)
+		}
+
+		if ghost.Nick != nick {
 			changeMsg := &irc.Message{
 				Prefix:  ghost.Prefix(),
 				Command: irc.NICK,
-				Params:  []string{info.Nick},
+				Params:  []string{nick},
 			}
 			u.Encode(changeMsg)
 		}
 
-		ghost.UserInfo = info
+		ghostInfo := *info
+		ghostInfo.Nick = nick
+		if ghostInfo.Bot {
+			ghostInfo.Host = "bot"
+		}
+		ghost.UserInfo = &ghostInfo
 
 		return ghost
 	}
 
-	ghost := NewUser(u.Conn)
-	ghost.UserInfo = info
+	ghost := u.newGhost(info, nil)
 
 	u.Srv.Add(ghost)
 
@@ -543,19 +681,75 @@ func (u *User) updateUserFromInfo(info *bridge.UserInfo) *User {
 }
 
 func (u *User) createUserFromInfo(info *bridge.UserInfo) *User {
+	u.nickMutex.Lock()
+	defer u.nickMutex.Unlock()
+
 	if ghost, ok := u.Srv.HasUserID(info.User); ok {
 		return ghost
 	}
 
-	ghost := NewUser(u.Conn)
-	ghost.UserInfo = info
-	ghost.Nick = sanitizeNick(ghost.Nick)
+	ghost := u.newGhost(info, nil)
 
 	u.Srv.Add(ghost)
 
 	return ghost
 }
 
+// newGhost creates a ghost user for info. Info is copied, it's cached by the bridge and
+// the nick can differ from the IRC one (eg collisions or bots).
+func (u *User) newGhost(info *bridge.UserInfo, taken map[string]string) *User {
+	ghostInfo := *info
+	ghostInfo.Nick = u.resolveNick(info.User, u.botNick(info), taken)
+	if ghostInfo.Bot {
+		ghostInfo.Host = "bot"
+	}
+
+	ghost := NewUser(u.Conn)
+	ghost.UserInfo = &ghostInfo
+
+	return ghost
+}
+
+// botNick returns the nick of info with the BotSuffix added for bots.
+func (u *User) botNick(info *bridge.UserInfo) string {
+	if !info.Bot || u.br == nil {
+		return info.Nick
+	}
+
+	suffix := "|bot"
+	if u.config().IsSet(u.br.Protocol() + ".botsuffix") {
+		suffix = u.config().GetString(u.br.Protocol() + ".botsuffix")
+	}
+
+	return info.Nick + suffix
+}
+
+// botChannel returns the channel messages of sender are routed to when it's a bot and BotChannel is set.
+func (u *User) botChannel(sender *bridge.UserInfo) Channel {
+	name := u.config().GetString(u.br.Protocol() + ".botchannel")
+	if name == "" || !sender.Bot || sender.Me {
+		return nil
+	}
+
+	return u.Srv.Channel(name)
+}
+
+// ghostNick returns the IRC nick to use for messages of info, without creating a ghost user.
+// This also handles nicks which differ from the account (eg webhook overrides).
+func (u *User) ghostNick(info *bridge.UserInfo) string {
+	u.nickMutex.Lock()
+	defer u.nickMutex.Unlock()
+
+	if ghost, ok := u.Srv.HasUserID(info.User); ok {
+		nick := sanitizeNick(u.botNick(info))
+		if ghost.Nick == nick || ghost.Nick == nick+nickSuffix(info.User) {
+			return ghost.Nick
+		}
+	}
+
+	return u.resolveNick(info.User, u.botNick(info), nil)
+}
+
 func (u *User) addUsersToChannel(users []*User, channel string, channelID string) {
 	logger.Debugf("adding %d to %s", len(users), channel)
 
@@ -564,6 +758,12 @@ func (u *User) addUsersToChannel(users []*User, channel string, channelID string
 	ch.BatchJoin(users)
 }
 
+// optionalChannels are our own channels joined when their setting is on.
+var optionalChannels = []struct{ name, setting string }{
+	{highlightsChannel, "highlights"},
+	{threadsChannel, "threadsonly"},
+}
+
 func (u *User) addUsersToChannels() {
 	// wait until the bridge is ready
 	for u.br == nil {
@@ -585,34 +785,62 @@ func (u *User) addUsersToChannels() {
 		u.addUsersToChannel(users, "&users", "&users")
 
 		// join ourself
-		ch.Join(u) //nolint:errcheck
+		u.joinOwnChannel(ch)
 	}
 
 	if u.br.Protocol() == "mastodon" {
 		ch = srv.Channel("mastodon")
-		ch.Join(u) //nolint:errcheck
+		u.joinOwnChannel(ch)
 	}
 
 	// channel that receives messages from channels not joined on irc
 	ch = srv.Channel("&messages")
-	ch.Join(u)
+	u.joinOwnChannel(ch)
+
+	// channels that receive a copy of the messages mentioning us and the replies of the threads we follow
+	for _, oc := range optionalChannels {
+		if u.config().GetBool(u.br.Protocol() + "." + oc.setting) {
+			ch = srv.Channel(oc.name)
+			u.joinOwnChannel(ch)
+		}
+	}
+
+	// channels that receive the messages of the channels matching their regexps
+	u.syncVirtualChannels()
+
+	// channel that receives the messages of bots
+	if name := u.config().GetString(u.br.Protocol() + ".botchannel"); name != "" {
+		ch = srv.Channel(name)
+		u.joinOwnChannel(ch)
+	}
 
-	channels := make(chan *bridge.ChannelInfo, 5)
+	// before joining, muted channels may not be joined and categories decide the order.
+	u.loadChannelNotify()
+	u.loadCategories()
+	u.loadFollowed()
+
+	brchannels := u.br.GetChannels()
+	u.sortChannels(brchannels)
+
+	channels := make(chan replayChannel, 5)
 	for i := 0; i < 10; i++ {
-		go u.addUserToChannelWorker(channels, throttle)
+		go u.addUserToChannelWorker(channels)
 	}
 
-	for _, brchannel := range u.br.GetChannels() {
+	for _, brchannel := range brchannels {
 		logger.Debugf("Adding channel %#v", brchannel)
+		u.claimChannel(brchannel.ID)
 
 		// only joindm when specified
-		if brchannel.DM && !u.v.GetBool(u.br.Protocol()+".joindm") {
+		if brchannel.DM && !u.config().GetBool(u.br.Protocol()+".joindm") {
 			logger.Debugf("Skipping IM channel %s", brchannel.Name)
 
 			continue
 		}
 
-		channels <- brchannel
+		// join here to join in order, the workers replay.
+		<-throttle.C
+		channels <- replayChannel{brchannel, u.createSpoof(brchannel)}
 	}
 
 	close(channels)
@@ -632,155 +860,193 @@ func (u *User) createSpoof(mmchannel *bridge.ChannelInfo) func(string, string, .
 		}
 	}
 
-	channelName := mmchannel.Name
-
-	if mmchannel.TeamID != u.br.GetMe().TeamID || u.v.GetBool(u.br.Protocol()+".prefixmainteam") {
-		channelName = u.br.GetTeamName(mmchannel.TeamID) + "/" + mmchannel.Name
-	}
-
-	u.syncChannel(mmchannel.ID, "#"+channelName)
+	u.syncChannel(mmchannel.ID, u.br.GetChannelName(mmchannel.ID))
 	ch := u.Srv.Channel(mmchannel.ID)
 
 	return ch.SpoofMessage
 }
 
-//nolint:funlen,gocognit,gocyclo,cyclop
-func (u *User) addUserToChannelWorker(channels <-chan *bridge.ChannelInfo, throttle *time.Ticker) {
-	for brchannel := range channels {
+// replayChannel is a channel we joined with how to replay its posts.
+type replayChannel struct {
+	brchannel *bridge.ChannelInfo
+	spoof     func(string, string, ...int)
+}
+
+func (u *User) addUserToChannelWorker(channels <-chan replayChannel) {
+	for rc := range channels {
+		brchannel, spoof := rc.brchannel, rc.spoof
 		logger.Debug("addUserToChannelWorker", brchannel)
 
-		<-throttle.C
-		// exclude direct messages
-		spoof := u.createSpoof(brchannel)
+		if u.mutedMode(brchannel.ID) != "" {
+			continue
+		}
 
-		since := u.br.GetLastViewedAt(brchannel.ID)
+		since, logSince := u.replaySince(brchannel)
 		// ignore invalid/deleted/old channels
 		if since == 0 {
 			continue
 		}
 
-		logSince := "server"
-		channame := brchannel.Name
-		if !brchannel.DM {
-			channame = fmt.Sprintf("#%s", brchannel.Name)
-		}
+		u.replayPosts(brchannel, spoof, since, logSince)
+	}
+}
 
-		// We used to stored last viewed at if present.
-		var lastViewedAt int64
-		key := brchannel.ID
-		err := u.lastViewedAtDB.View(func(tx *bolt.Tx) error {
-			b := tx.Bucket([]byte(u.User))
-			if v := b.Get([]byte(key)); v != nil {
-				lastViewedAt = int64(binary.LittleEndian.Uint64(v))
-			}
-			return nil
-		})
-		if err != nil {
-			logger.Errorf("something wrong with u.lastViewedAtDB.View for %s for channel %s (%s)", u.Nick, channame, brchannel.ID)
-			lastViewedAt = since
-		}
+// replaySince returns from when the posts of brchannel have to be replayed.
+func (u *User) replaySince(brchannel *bridge.ChannelInfo) (int64, string) {
+	since := u.br.GetLastViewedAt(brchannel.ID)
+	if since == 0 {
+		return 0, ""
+	}
+
+	logSince := "server"
 
-		// But only use the stored last viewed if it's later than what the server knows.
-		if lastViewedAt > since {
-			since = lastViewedAt + 1
-			logSince = "stored"
+	// We used to stored last viewed at if present.
+	var lastViewedAt int64
+	key := brchannel.ID
+	err := u.lastViewedAtDB.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(u.User))
+		if v := b.Get([]byte(key)); v != nil {
+			lastViewedAt = int64(binary.LittleEndian.Uint64(v))
 		}
+		return nil
+	})
+	if err != nil {
+		logger.Errorf("something wrong with u.lastViewedAtDB.View for %s for channel %s (%s)", u.Nick, brchannel.Name, brchannel.ID)
+		lastViewedAt = since
+	}
 
-		// post everything to the channel you haven't seen yet
-		postlist := u.br.GetPostsSince(brchannel.ID, since)
-		if postlist == nil {
-			// if the channel is not from the primary team id, we can't get posts
-			if brchannel.TeamID == u.br.GetMe().TeamID {
-				logger.Errorf("something wrong with getPostsSince for %s for channel %s (%s)", u.Nick, channame, brchannel.ID)
-			}
+	// But only use the stored last viewed if it's later than what the server knows.
+	if lastViewedAt > since {
+		since = lastViewedAt + 1
+		logSince = "stored"
+	}
+
+	return since, logSince
+}
+
+// replayPosts shows the posts of brchannel since the given time, except the ones we've already shown,
+// it returns the number of posts shown.
+//
+//nolint:funlen,gocognit,gocyclo,cyclop
+func (u *User) replayPosts(brchannel *bridge.ChannelInfo, spoof func(string, string, ...int), since int64, logSince string) int {
+	channame := brchannel.Name
+	if !brchannel.DM {
+		channame = fmt.Sprintf("#%s", brchannel.Name)
+	}
+
+	// post everything to the channel you haven't seen yet
+	postlist := u.br.GetPostsSince(brchannel.ID, since)
+	if postlist == nil {
+		logger.Errorf("something wrong with getPostsSince for %s for channel %s (%s)", u.Nick, channame, brchannel.ID)
+		return 0
+	}
+
+	showReplayHdr := true
+
+	mmPostList, _ := postlist.(*model.PostList)
+	if mmPostList == nil {
+		return 0
+	}
+
+	shown := 0
+
+	// traverse the order in reverse
+	for i := len(mmPostList.Order) - 1; i >= 0; i-- {
+		p := mmPostList.Posts[mmPostList.Order[i]]
+		if p.Type == model.PostTypeJoinLeave {
 			continue
 		}
 
-		showReplayHdr := true
+		if p.DeleteAt > p.CreateAt {
+			continue
+		}
 
-		mmPostList, _ := postlist.(*model.PostList)
-		if mmPostList == nil {
+		// GetPostsSince will return older messages with reaction
+		// changes since LastViewedAt. This will be confusing as
+		// the user will think it's a duplicate, or a post out of
+		// order. Plus, we don't show reaction changes when
+		// relaying messages/logs so let's skip these.
+		if p.CreateAt < since {
 			continue
 		}
-		// traverse the order in reverse
-		for i := len(mmPostList.Order) - 1; i >= 0; i-- {
-			p := mmPostList.Posts[mmPostList.Order[i]]
-			if p.Type == model.PostTypeJoinLeave {
-				continue
-			}
 
-			if p.DeleteAt > p.CreateAt {
-				continue
-			}
+		// already shown, eg when we resync after a reconnect.
+		if !u.markSeen(p.Id) && logSince != replayUnread {
+			continue
+		}
 
-			// GetPostsSince will return older messages with reaction
-			// changes since LastViewedAt. This will be confusing as
-			// the user will think it's a duplicate, or a post out of
-			// order. Plus, we don't show reaction changes when
-			// relaying messages/logs so let's skip these.
-			if p.CreateAt < since {
-				continue
-			}
+		shown++
 
-			ts := time.Unix(0, p.CreateAt*int64(time.Millisecond))
+		ts := time.Unix(0, p.CreateAt*int64(time.Millisecond))
 
-			props := p.GetProps()
-			botname, override := props["override_username"].(string)
-			user := u.br.GetUser(p.UserId)
-			nick := user.Nick
-			if override {
-				nick = botname
-			}
-
-			if p.Type == model.PostTypeAddToTeam || p.Type == model.PostTypeRemoveFromTeam {
-				nick = systemUser
-			}
+		props := p.GetProps()
+		botname, override := props["override_username"].(string)
+		user := u.br.GetUser(p.UserId)
+		nick := u.ghostNick(user)
+		if override {
+			// the same user the mattermost bridge creates for webhooks.
+			nick = u.ghostNick(&bridge.UserInfo{Nick: botname, User: p.UserId + "/" + botname, Bot: true})
+		}
 
-			for _, post := range strings.Split(p.Message, "\n") {
-				if showReplayHdr {
-					date := ts.Format("2006-01-02 15:04:05")
-					if brchannel.DM {
-						spoof(nick, fmt.Sprintf("\x02Replaying msgs since %s\x0f", date))
-					} else {
-						spoof("matterircd", fmt.Sprintf("\x02Replaying msgs since %s\x0f", date))
-					}
-					logger.Infof("Replaying msgs for %s for %s (%s) since %s (%s)", u.Nick, channame, brchannel.ID, date, logSince)
-					showReplayHdr = false
-				}
+		if p.Type == model.PostTypeAddToTeam || p.Type == model.PostTypeRemoveFromTeam {
+			nick = systemUser
+		}
 
-				if nick == systemUser {
-					post = "\x1d" + post + "\x1d"
+		for _, post := range strings.Split(p.Message, "\n") {
+			if showReplayHdr {
+				date := ts.Format("2006-01-02 15:04:05")
+				if brchannel.DM {
+					spoof(nick, fmt.Sprintf("\x02Replaying msgs since %s\x0f", date))
+				} else {
+					spoof("matterircd", fmt.Sprintf("\x02Replaying msgs since %s\x0f", date))
 				}
+				logger.Infof("Replaying msgs for %s for %s (%s) since %s (%s)", u.Nick, channame, brchannel.ID, date, logSince)
+				showReplayHdr = false
+			}
 
-				replayMsg := fmt.Sprintf("[%s] %s", ts.Format("15:04"), post)
-				if (u.v.GetBool(u.br.Protocol()+".prefixcontext") || u.v.GetBool(u.br.Protocol()+".suffixcontext")) && nick != systemUser {
-					threadMsgID := u.prefixContext(brchannel.ID, p.Id, p.RootId, "replay")
-					replayMsg = u.formatContextMessage(ts.Format("15:04"), threadMsgID, post)
-				}
-				spoof(nick, replayMsg)
+			if nick == systemUser {
+				post = "\x1d" + post + "\x1d"
 			}
 
-			if len(p.FileIds) == 0 {
-				continue
+			replayMsg := fmt.Sprintf("[%s] %s", ts.Format("15:04"), post)
+			if (u.config().GetBool(u.br.Protocol()+".prefixcontext") || u.config().GetBool(u.br.Protocol()+".suffixcontext")) && nick != systemUser {
+				threadMsgID := u.prefixContext(brchannel.ID, p.Id, p.RootId, "replay")
+				replayMsg = u.formatContextMessage(ts.Format("15:04"), threadMsgID, post)
 			}
+			spoof(nick, replayMsg)
+		}
 
-			for _, fname := range u.br.GetFileLinks(p.FileIds) {
-				fileMsg := "\x1ddownload file - " + fname + "\x1d"
-				if u.v.GetBool(u.br.Protocol()+".prefixcontext") || u.v.GetBool(u.br.Protocol()+".suffixcontext") {
-					threadMsgID := u.prefixContext(brchannel.ID, p.Id, p.RootId, "replay_file")
-					fileMsg = u.formatContextMessage(ts.Format("15:04"), threadMsgID, fileMsg)
-				}
-				spoof(nick, fileMsg)
+		if !user.Me && nick != systemUser {
+			from := nick
+			if !brchannel.DM {
+				from += "/" + channame
 			}
+
+			u.highlight(from, brchannel.ID, p.Id, p.RootId, ts.Format("15:04"), p.Message)
 		}
 
-		if len(mmPostList.Order) > 0 {
-			if !u.v.GetBool(u.br.Protocol() + ".disableautoview") {
-				u.updateLastViewed(brchannel.ID)
+		if len(p.FileIds) == 0 {
+			continue
+		}
+
+		for _, fname := range u.br.GetFileLinks(p.FileIds) {
+			fileMsg := "\x1ddownload file - " + fname + "\x1d"
+			if u.config().GetBool(u.br.Protocol()+".prefixcontext") || u.config().GetBool(u.br.Protocol()+".suffixcontext") {
+				threadMsgID := u.prefixContext(brchannel.ID, p.Id, p.RootId, "replay_file")
+				fileMsg = u.formatContextMessage(ts.Format("15:04"), threadMsgID, fileMsg)
 			}
-			u.saveLastViewedAt(brchannel.ID)
+			spoof(nick, fileMsg)
+		}
+	}
+
+	if len(mmPostList.Order) > 0 {
+		if !u.config().GetBool(u.br.Protocol() + ".disableautoview") {
+			u.updateLastViewed(brchannel.ID)
 		}
+		u.saveLastViewedAt(brchannel.ID)
 	}
+
+	return shown
 }
 
 func (u *User) MsgUser(toUser *User, msg string) {
@@ -841,20 +1107,26 @@ func (u *User) syncChannel(id string, name string) {
 }
 
 func (u *User) mayJoin(channelID string) bool {
+	if u.mutedMode(channelID) != "" {
+		logger.Tracef("mayjoin %t ch: %s, muted", false, channelID)
+		return false
+	}
+
 	ch := u.Srv.Channel(channelID)
+	categories := u.categoriesOf(channelID)
 
-	jo := u.v.GetStringSlice(u.br.Protocol() + ".joinonly")
-	ji := u.v.GetStringSlice(u.br.Protocol() + ".joininclude")
-	je := u.v.GetStringSlice(u.br.Protocol() + ".joinexclude")
+	jo := u.config().GetStringSlice(u.br.Protocol() + ".joinonly")
+	ji := u.config().GetStringSlice(u.br.Protocol() + ".joininclude")
+	je := u.config().GetStringSlice(u.br.Protocol() + ".joinexclude")
 
 	switch {
 	// if we have joinonly channels specified we are only allowed to join those
-	case len(jo) != 0 && !stringInRegexp(ch.String(), jo):
+	case len(jo) != 0 && !matchJoin(ch.String(), categories, jo):
 		logger.Tracef("mayjoin 0 %t ch: %s, match: %s", false, ch.String(), jo)
 		return false
 	// we only have exclude, do not join if in exclude
 	case len(ji) == 0 && len(je) != 0:
-		mayjoin := !stringInRegexp(ch.String(), je)
+		mayjoin := !matchJoin(ch.String(), categories, je)
 		logger.Tracef("mayjoin 1 %t ch: %s, match: %s", mayjoin, ch.String(), je)
 		return mayjoin
 	// nothing specified, everything may join
@@ -863,13 +1135,13 @@ func (u *User) mayJoin(channelID string) bool {
 		return true
 	// if we don't have joinexclude, then joininclude behaves as joinonly
 	case len(ji) != 0 && len(je) == 0:
-		mayjoin := stringInRegexp(ch.String(), ji)
+		mayjoin := matchJoin(ch.String(), categories, ji)
 		logger.Tracef("mayjoin 3 %t ch: %s, match: %s", mayjoin, ch.String(), ji)
 		return mayjoin
 	// joininclude overrides the joinexclude
 	case len(ji) != 0 && len(je) != 0:
 		// if explicit in ji we also may join
-		mayjoin := stringInRegexp(ch.String(), ji)
+		mayjoin := matchJoin(ch.String(), categories, ji)
 		logger.Tracef("mayjoin 4 %t ch: %s, match: %s", mayjoin, ch.String(), ji)
 		return mayjoin
 	}
@@ -880,13 +1152,13 @@ func (u *User) mayJoin(channelID string) bool {
 }
 
 func (u *User) isValidServer(server, protocol string) bool {
-	if len(u.v.GetStringSlice(protocol+".restrict")) == 0 {
+	if len(u.config().GetStringSlice(protocol+".restrict")) == 0 {
 		return true
 	}
 
-	logger.Debugf("restrict: %s", u.v.GetStringSlice(protocol+".restrict"))
+	logger.Debugf("restrict: %s", u.config().GetStringSlice(protocol+".restrict"))
 
-	for _, srv := range u.v.GetStringSlice(protocol + ".restrict") {
+	for _, srv := range u.config().GetStringSlice(protocol + ".restrict") {
 		if srv == server {
 			return true
 		}
@@ -895,28 +1167,71 @@ func (u *User) isValidServer(server, protocol string) bool {
 	return false
 }
 
-func (u *User) loginTo(protocol string) error {
-	var err error
+func (u *User) newBridge(protocol string, cred bridge.Credentials) (bridge.Bridger, error) {
+	var (
+		br  bridge.Bridger
+		err error
+	)
 
 	switch protocol {
 	case "mastodon":
 		u.eventChan = make(chan *bridge.Event)
-		u.br, err = mastodon.New(u.v, u.Credentials, u.eventChan, u.addUsersToChannels)
+		br, err = mastodon.New(u.config(), cred, u.eventChan, u.addUsersToChannels)
 	case "slack":
 		u.eventChan = make(chan *bridge.Event)
-		u.br, err = slack.New(u.v, u.Credentials, u.eventChan, u.addUsersToChannels)
+		br, err = slack.New(u.config(), cred, u.eventChan, u.addUsersToChannels)
 	case "mattermost":
 		u.eventChan = make(chan *bridge.Event)
-		if u.v.GetBool("mattermost.ignoreserverversion") || strings.HasPrefix(u.getMattermostVersion(), "7.") || strings.HasPrefix(u.getMattermostVersion(), "8.") || strings.HasPrefix(u.getMattermostVersion(), "9.") || strings.HasPrefix(u.getMattermostVersion(), "10.") {
-			u.br, _, err = mattermost.New(u.v, u.Credentials, u.eventChan, u.addUsersToChannels)
+		if u.config().GetBool("mattermost.ignoreserverversion") || strings.HasPrefix(u.getMattermostVersion(), "7.") || strings.HasPrefix(u.getMattermostVersion(), "8.") || strings.HasPrefix(u.getMattermostVersion(), "9.") || strings.HasPrefix(u.getMattermostVersion(), "10.") {
+			br, _, err = mattermost.New(u.config(), cred, u.eventChan, u.addUsersToChannels)
 		} else {
-			return fmt.Errorf("mattermost version %s not supported", u.getMattermostVersion())
+			return nil, fmt.Errorf("mattermost version %s not supported", u.getMattermostVersion())
 		}
 	}
-	if err != nil {
-		return err
+
+	return br, err
+}
+
+func (u *User) loginTo(protocol string) error {
+	var (
+		br  bridge.Bridger
+		err error
+	)
+
+	if u.attachSession(protocol) {
+		return nil
 	}
 
+	// our own copy of the config, the settings of our account go on top of it.
+	u.resetConfig()
+
+	// try the session of our previous login first.
+	if token, ok := u.storedSessionToken(protocol, u.Credentials); ok {
+		cred := u.Credentials
+		cred.SessionToken = token
+
+		br, err = u.newBridge(protocol, cred)
+		if err != nil {
+			logger.Infof("stored session of %s rejected, doing a full login: %s", u.Credentials.Login, err)
+			u.deleteSessionToken(protocol, u.Credentials)
+			br = nil
+		}
+	}
+
+	if br == nil {
+		br, err = u.newBridge(protocol, u.Credentials)
+		if err != nil {
+			return err
+		}
+
+		u.saveSessionToken(protocol, u.Credentials, br)
+	}
+
+	if u.network != "" {
+		br = &networkBridge{Bridger: br, network: u.network}
+	}
+	u.br = br
+
 	status, _ := u.br.StatusUser(u.br.GetMe().User)
 	if status == "away" {
 		u.Srv.EncodeMessage(u, irc.RPL_NOWAWAY, []string{u.Nick}, "You have been marked as being away")
@@ -927,6 +1242,8 @@ func (u *User) loginTo(protocol string) error {
 	u.User = info.User
 	u.MentionKeys = info.MentionKeys
 
+	u.loadSettings()
+
 	err = u.lastViewedAtDB.Update(func(tx *bolt.Tx) error {
 		_, err2 := tx.CreateBucketIfNotExists([]byte(u.User))
 		return err2
@@ -935,6 +1252,10 @@ func (u *User) loginTo(protocol string) error {
 		return err
 	}
 
+	u.lastEventAt = time.Now()
+
+	u.startSession(protocol)
+
 	return nil
 }
 
@@ -1003,9 +1324,9 @@ func (u *User) updateMsgMapIndex(channelID string, counter int, messageID string
 func (u *User) formatContextMessage(ts, threadMsgID, msg string) string {
 	var formattedMsg string
 	switch {
-	case u.v.GetBool(u.br.Protocol() + ".prefixcontext"):
+	case u.config().GetBool(u.br.Protocol() + ".prefixcontext"):
 		formattedMsg = threadMsgID + " " + msg
-	case u.v.GetBool(u.br.Protocol() + ".suffixcontext"):
+	case u.config().GetBool(u.br.Protocol() + ".suffixcontext"):
 		formattedMsg = msg + " " + threadMsgID
 	}
 	if ts != "" {
@@ -1018,15 +1339,15 @@ func (u *User) prefixContext(channelID, messageID, parentID, event string) strin
 	logger.Tracef("prefixContext ch %s msg %s parent %s event %s", channelID, messageID, parentID, event)
 
 	prefixChar := "->"
-	if u.v.GetBool(u.br.Protocol() + ".unicode") {
+	if u.config().GetBool(u.br.Protocol() + ".unicode") {
 		prefixChar = "↪"
 	}
 
-	if u.v.GetString(u.br.Protocol()+".threadcontext") == "mattermost" || u.v.GetString(u.br.Protocol()+".threadcontext") == "mattermost+post" {
+	if u.config().GetString(u.br.Protocol()+".threadcontext") == "mattermost" || u.config().GetString(u.br.Protocol()+".threadcontext") == "mattermost+post" {
 		if parentID == "" {
 			return fmt.Sprintf("[@@%s]", messageID)
 		}
-		if u.v.GetString(u.br.Protocol()+".threadcontext") == "mattermost" || parentID == messageID {
+		if u.config().GetString(u.br.Protocol()+".threadcontext") == "mattermost" || parentID == messageID {
 			return fmt.Sprintf("[%s@@%s]", prefixChar, parentID)
 		}
 		return fmt.Sprintf("[%s@@%s,@@%s]", prefixChar, parentID, messageID)
@@ -1106,7 +1427,7 @@ func (u *User) saveLastViewedAt(channelID string) {
 func (u *User) getMattermostVersion() string {
 	proto := "https"
 
-	if u.v.GetBool("mattermost.insecure") {
+	if u.config().GetBool("mattermost.insecure") {
 		proto = "http"
 	}
 
@@ -1122,128 +1443,55 @@ func (u *User) getMattermostVersion() string {
 }
 
 func (u *User) handleMessageThreadContext(channelID, messageID, parentID, event, text string) (string, string, string, bool, int) {
-	newText := text
 	prefix := ""
 	suffix := ""
 	maxlen := 440
 	showContext := false
 
+	context := ""
+	width := maxlen
+	if u.config().GetBool(u.br.Protocol()+".prefixcontext") || u.config().GetBool(u.br.Protocol()+".suffixcontext") {
+		context = u.prefixContext(channelID, messageID, parentID, event)
+		width -= len(context) + 1
+	}
+
+	text = u.formatMarkdown(text, width)
+	newText := text
+
 	switch {
-	case u.v.GetBool(u.br.Protocol()+".prefixcontext") && strings.HasPrefix(text, "\x01"):
-		prefix = u.prefixContext(channelID, messageID, parentID, event) + " "
+	case u.config().GetBool(u.br.Protocol()+".prefixcontext") && strings.HasPrefix(text, "\x01"):
+		prefix = context + " "
 		newText = strings.Replace(text, "\x01ACTION ", "\x01ACTION "+prefix, 1)
 		maxlen = len(newText)
-	case u.v.GetBool(u.br.Protocol()+".prefixcontext") && u.v.GetBool(u.br.Protocol()+".showcontextmulti"):
-		prefix = u.prefixContext(channelID, messageID, parentID, event) + " "
-		newText = text
+	case u.config().GetBool(u.br.Protocol()+".prefixcontext") && u.config().GetBool(u.br.Protocol()+".showcontextmulti"):
+		prefix = context + " "
 		showContext = true
 		maxlen -= len(prefix)
-	case u.v.GetBool(u.br.Protocol() + ".prefixcontext"):
-		prefix = u.prefixContext(channelID, messageID, parentID, event) + " "
+	case u.config().GetBool(u.br.Protocol() + ".prefixcontext"):
+		prefix = context + " "
 		newText = prefix + text
-	case u.v.GetBool(u.br.Protocol()+".suffixcontext") && strings.HasSuffix(text, "\x01"):
-		suffix = " " + u.prefixContext(channelID, messageID, parentID, event)
+	case u.config().GetBool(u.br.Protocol()+".suffixcontext") && strings.HasSuffix(text, "\x01"):
+		suffix = " " + context
 		newText = strings.Replace(text, " \x01", suffix+" \x01", 1)
 		maxlen = len(newText)
-	case u.v.GetBool(u.br.Protocol()+".suffixcontext") && u.v.GetBool(u.br.Protocol()+".showcontextmulti"):
-		suffix = " " + u.prefixContext(channelID, messageID, parentID, event)
-		newText = text
+	case u.config().GetBool(u.br.Protocol()+".suffixcontext") && u.config().GetBool(u.br.Protocol()+".showcontextmulti"):
+		suffix = " " + context
 		showContext = true
 		maxlen -= len(suffix)
-	case u.v.GetBool(u.br.Protocol() + ".suffixcontext"):
-		suffix = " " + u.prefixContext(channelID, messageID, parentID, event)
+	case u.config().GetBool(u.br.Protocol() + ".suffixcontext"):
+		suffix = " " + context
 		newText = strings.TrimRight(text, "\n") + suffix
 	}
 
 	return newText, prefix, suffix, showContext, maxlen
 }
 
-//nolint:gocyclo
-func (u *User) formatCodeBlockText(text string, prefix string, codeBlockBackTick bool, codeBlockTilde bool, lexer string) (string, bool, bool, string) {
-	// skip empty lines for anything not part of a code block.
-	if text == "" {
-		if codeBlockBackTick || codeBlockTilde {
-			return " ", codeBlockBackTick, codeBlockTilde, lexer
-		}
-		return "", codeBlockBackTick, codeBlockTilde, lexer
-	}
-
-	syntaxHighlighting := u.v.GetString(u.br.Protocol() + ".syntaxhighlighting")
-
-	if (strings.HasPrefix(text, "```") || strings.HasPrefix(text, prefix+"```")) && !codeBlockTilde {
-		codeBlockBackTick = !codeBlockBackTick
-		if codeBlockBackTick {
-			lexer = strings.TrimSpace(strings.TrimPrefix(strings.TrimPrefix(text, "```"), prefix+"```"))
-		}
-		return text, codeBlockBackTick, codeBlockTilde, lexer
-	}
-	if (strings.HasPrefix(text, "~~~") || strings.HasPrefix(text, prefix+"~~~")) && !codeBlockBackTick {
-		codeBlockTilde = !codeBlockTilde
-		if codeBlockTilde {
-			lexer = strings.TrimSpace(strings.TrimPrefix(strings.TrimPrefix(text, "~~~"), prefix+"~~~"))
-		}
-		return text, codeBlockBackTick, codeBlockTilde, lexer
-	}
-
-	if !(codeBlockBackTick || codeBlockTilde) || syntaxHighlighting == "" || lexer == "" {
-		return text, codeBlockBackTick, codeBlockTilde, lexer
-	}
-
-	formatter := "terminal256"
-	style := "pygments"
-	v := strings.SplitN(syntaxHighlighting, ":", 2)
-	if len(v) == 2 {
-		formatter = v[0]
-		style = v[1]
-	}
-
-	var b bytes.Buffer
-	err := quick.Highlight(&b, text, lexer, formatter, style)
-	if err == nil {
-		text = b.String()
-		// Work around https://github.com/alecthomas/chroma/issues/716
-		text = strings.ReplaceAll(text, "\n", "")
-	}
-
-	return text, codeBlockBackTick, codeBlockTilde, lexer
-}
-
-// Use static initialisation to optimize.
-// Bold & Italic - https://www.markdownguide.org/basic-syntax#bold-and-italic
-var boldItalicRegExp = []*regexp.Regexp{
-	regexp.MustCompile(`(?:\*\*\*)+?(.+?)(?:\*\*\*)+?`),
-	regexp.MustCompile(`\b(?:\_\_\_)+?(.+?)(?:\_\_\_)+?\b`),
-	regexp.MustCompile(`\b(?:\_\_\*)+?(.+?)(?:\*\_\_)+?\b`),
-	regexp.MustCompile(`\b(?:\*\*\_)+?(.+?)(?:\_\*\*)+?\b`),
-}
-
-// Bold - https://www.markdownguide.org/basic-syntax#bold
-var boldRegExp = []*regexp.Regexp{
-	regexp.MustCompile(`(?:\*\*)+?(.+?)(?:\*\*)+?`),
-	regexp.MustCompile(`\b(?:\_\_)+?(.+?)(?:\_\_)+?\b`),
-}
-
-// Italic - https://www.markdownguide.org/basic-syntax#italic
-var italicRegExp = []*regexp.Regexp{
-	regexp.MustCompile(`(?:\*)+?([^\*]+?)(?:\*)+?`),
-	regexp.MustCompile(`\b(?:\_)+?([^_]+?)(?:\_)+?\b`),
-}
-
-func markdown2irc(msg string) string {
-	// Bold & Italic 0x02+0x1d
-	for _, re := range boldItalicRegExp {
-		msg = re.ReplaceAllString(msg, "\x02\x1d$1\x1d\x02")
-	}
-
-	// Bold 0x02
-	for _, re := range boldRegExp {
-		msg = re.ReplaceAllString(msg, "\x02$1\x02")
-	}
-
-	// Italic 0x1d
-	for _, re := range italicRegExp {
-		msg = re.ReplaceAllString(msg, "\x1d$1\x1d")
-	}
+// formatMarkdown renders the markdown in text to IRC formatting, width is used to align tables.
+func (u *User) formatMarkdown(text string, width int) string {
+	r := newMarkdownRenderer(width,
+		!u.config().GetBool(u.br.Protocol()+".disableircemphasis"),
+		u.config().GetBool(u.br.Protocol()+".unicode"),
+		u.config().GetString(u.br.Protocol()+".syntaxhighlighting"))
 
-	return msg
+	return r.Render(text)
 }
@@ -0,0 +1,319 @@
+package irckit
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/42wim/matterircd/bridge"
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/sorcix/irc"
+)
+
+// Virtual channels are & channels showing the messages of the channels matching their
+// regexps, like &messages does for the channels we aren't on, eg
+//
+//	[mattermost]
+//	VirtualChannels = { "&alerts" = ["#ops-.*", "#monitoring"] }
+//
+// The messages are copied there as nick/#channel, with virtualchannelsmove they're only
+// shown there. Replies with a context (@@id) go to the channel of the message.
+
+// maxVirtualSources is the number of messages remembered to reply to from virtual channels.
+const maxVirtualSources = 1000
+
+// reservedChannels are our own channels, they can't be virtual channels.
+var reservedChannels = []string{"&messages", "&users", highlightsChannel, threadsChannel, adminChannel}
+
+// checkVirtualChannel checks the name and regexps of a virtual channel.
+func checkVirtualChannel(name string, regexps []string) error {
+	if !strings.HasPrefix(name, "&") || len(name) == 1 || strings.ContainsAny(name, ". ,") {
+		return fmt.Errorf("%s: virtual channels are named &<name>", name)
+	}
+
+	for _, reserved := range reservedChannels {
+		if strings.EqualFold(name, reserved) {
+			return fmt.Errorf("%s is one of our own channels", name)
+		}
+	}
+
+	for _, entry := range regexps {
+		if _, err := regexp.Compile(entry); err != nil {
+			return fmt.Errorf("%s: %s", name, err)
+		}
+	}
+
+	return nil
+}
+
+// parseVirtualChannels parses &name=regexp,regexp entries.
+func parseVirtualChannels(entries []string) (map[string][]string, error) {
+	channels := make(map[string][]string)
+
+	for _, entry := range entries {
+		name, list, _ := strings.Cut(entry, "=")
+		name = strings.ToLower(name)
+
+		regexps := strings.FieldsFunc(list, func(r rune) bool { return r == ',' })
+		if len(regexps) == 0 {
+			return nil, fmt.Errorf("%s needs one or more channel regexps, eg %s=#ops-.*,#monitoring", name, name)
+		}
+
+		if err := checkVirtualChannel(name, regexps); err != nil {
+			return nil, err
+		}
+
+		channels[name] = regexps
+	}
+
+	return channels, nil
+}
+
+// formatVirtualChannels formats channels like parseVirtualChannels parses them.
+func formatVirtualChannels(channels map[string][]string) string {
+	entries := make([]string, 0, len(channels))
+	for name, regexps := range channels {
+		entries = append(entries, name+"="+strings.Join(regexps, ","))
+	}
+
+	sort.Strings(entries)
+
+	return strings.Join(entries, " ")
+}
+
+// virtualChannels returns (a copy of) the virtual channels of u with their regexps.
+func (u *User) virtualChannels() map[string][]string {
+	channels := make(map[string][]string)
+	for name, regexps := range u.config().GetStringMapStringSlice(u.br.Protocol() + ".virtualchannels") {
+		channels[strings.ToLower(name)] = regexps
+	}
+
+	return channels
+}
+
+// isVirtualChannel tells if name is one of our virtual channels.
+func (u *User) isVirtualChannel(name string) bool {
+	if u.br == nil || !strings.HasPrefix(name, "&") {
+		return false
+	}
+
+	_, ok := u.virtualChannels()[strings.ToLower(name)]
+
+	return ok
+}
+
+// virtualChannelsOf returns the virtual channels showing the channel named name.
+func (u *User) virtualChannelsOf(name string) []string {
+	var names []string
+
+	for vname, regexps := range u.virtualChannels() {
+		for _, entry := range regexps {
+			re, err := regexp.Compile(entry)
+			if err != nil {
+				logger.Errorf("virtualchannels %s: %s", vname, err)
+				continue
+			}
+
+			if re.MatchString(name) {
+				names = append(names, vname)
+				break
+			}
+		}
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// syncVirtualChannels joins the virtual channels we have and parts the ones we don't have anymore.
+func (u *User) syncVirtualChannels() ([]string, []string) {
+	var joined, parted []string
+
+	channels := u.virtualChannels()
+
+	u.virtualMutex.Lock()
+	defer u.virtualMutex.Unlock()
+
+	for name := range channels {
+		if !u.virtualJoined[name] {
+			u.joinOwnChannel(u.Srv.Channel(name))
+			u.virtualJoined[name] = true
+			joined = append(joined, name)
+		}
+	}
+
+	for name := range u.virtualJoined {
+		if _, ok := channels[name]; !ok {
+			if ch, exists := u.Srv.HasChannel(name); exists {
+				ch.Part(u, "")
+			}
+
+			delete(u.virtualJoined, name)
+			parted = append(parted, name)
+		}
+	}
+
+	sort.Strings(joined)
+	sort.Strings(parted)
+
+	return joined, parted
+}
+
+// mirrorVirtual shows the message of event from nick in the virtual channels of its channel,
+// it returns false when there are none.
+func (u *User) mirrorVirtual(nick string, event *bridge.ChannelMessageEvent) bool {
+	if event.ChannelType == "D" {
+		return false
+	}
+
+	source := u.Srv.Channel(event.ChannelID).String()
+
+	names := u.virtualChannelsOf(source)
+	if len(names) == 0 {
+		return false
+	}
+
+	u.rememberVirtualSource(event.MessageID, event.ChannelID)
+
+	if event.ParentID != "" {
+		u.rememberVirtualSource(event.ParentID, event.ChannelID)
+	}
+
+	for _, name := range names {
+		u.spoofChannelMessage(u.Srv.Channel(name), nick+"/"+source, name, event)
+	}
+
+	return true
+}
+
+// rememberVirtualSource remembers channelID as the channel of post postID.
+func (u *User) rememberVirtualSource(postID, channelID string) {
+	if postID == "" {
+		return
+	}
+
+	u.virtualMutex.Lock()
+	defer u.virtualMutex.Unlock()
+
+	if _, ok := u.virtualSources[postID]; !ok {
+		u.virtualSourcesOrder = append(u.virtualSourcesOrder, postID)
+	}
+
+	u.virtualSources[postID] = channelID
+
+	if len(u.virtualSourcesOrder) > maxVirtualSources {
+		delete(u.virtualSources, u.virtualSourcesOrder[0])
+		u.virtualSourcesOrder = u.virtualSourcesOrder[1:]
+	}
+}
+
+// virtualSource returns the channel of post postID, "" if we don't know it.
+func (u *User) virtualSource(postID string) string {
+	u.virtualMutex.Lock()
+	channelID, ok := u.virtualSources[postID]
+	u.virtualMutex.Unlock()
+
+	if ok {
+		return channelID
+	}
+
+	// eg a post ID from elsewhere.
+	postlist, _ := u.br.GetPostThread(postID).(*model.PostList)
+	if postlist == nil {
+		return ""
+	}
+
+	if p, ok := postlist.Posts[postID]; ok {
+		return p.ChannelId
+	}
+
+	return ""
+}
+
+// msgVirtual sends msg, said in virtual channel (or &threads) ch, as a reply in the channel of the message
+// its context refers to. It returns false when there's no context.
+func (u *User) msgVirtual(ch Channel, msg *irc.Message) bool {
+	threadID, text := parseThreadID(u, msg, ch.ID())
+	if threadID == "" {
+		return false
+	}
+
+	channelID := u.virtualSource(threadID)
+	if channelID == "" {
+		u.MsgSpoofUser(u, u.br.Protocol(), "msg: "+text+" could not be sent, the channel of "+threadID+" is unknown")
+		return true
+	}
+
+	msgID, err := u.br.MsgChannelThread(channelID, threadID, text)
+	if err != nil {
+		u.MsgSpoofUser(u, u.br.Protocol(), "msg: "+text+" could not be sent "+err.Error())
+		return true
+	}
+
+	u.rememberVirtualSource(msgID, channelID)
+	u.threadReplied(threadID)
+
+	u.msgLastMutex.Lock()
+	defer u.msgLastMutex.Unlock()
+	u.msgLast[ch.ID()] = [2]string{msgID, threadID}
+	u.msgLast[channelID] = [2]string{msgID, threadID}
+	u.saveLastViewedAt(channelID)
+
+	if u.config().GetBool(u.br.Protocol()+".prefixcontext") || u.config().GetBool(u.br.Protocol()+".suffixcontext") {
+		u.prefixContext(ch.ID(), msgID, threadID, "posted_self")
+	}
+
+	return true
+}
+
+func vchannel(u *User, toUser *User, args []string, service string) {
+	channels := u.virtualChannels()
+
+	switch {
+	case len(args) == 0:
+		if len(channels) == 0 {
+			u.MsgUser(toUser, "no virtual channels, add one with VCHANNEL &<name> <channel regexp>...")
+			return
+		}
+
+		for _, entry := range strings.Fields(formatVirtualChannels(channels)) {
+			u.MsgUser(toUser, entry)
+		}
+
+		return
+	case len(args) == 1:
+		name := strings.ToLower(args[0])
+		if _, ok := channels[name]; !ok {
+			u.MsgUser(toUser, "no virtual channel "+name)
+			return
+		}
+
+		delete(channels, name)
+	default:
+		name := strings.ToLower(args[0])
+		if err := checkVirtualChannel(name, args[1:]); err != nil {
+			u.MsgUser(toUser, err.Error())
+			return
+		}
+
+		channels[name] = args[1:]
+	}
+
+	if err := u.setSetting("virtualchannels", channels); err != nil {
+		u.MsgUser(toUser, "saving virtualchannels failed: "+err.Error())
+		return
+	}
+
+	joined, parted := u.syncVirtualChannels()
+
+	switch {
+	case len(joined) > 0:
+		u.MsgUser(toUser, "joined "+strings.Join(joined, ", "))
+	case len(parted) > 0:
+		u.MsgUser(toUser, "parted "+strings.Join(parted, ", "))
+	default:
+		u.MsgUser(toUser, "virtualchannels = "+formatVirtualChannels(channels))
+	}
+}
@@ -0,0 +1,80 @@
+package irckit
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseVirtualChannels(t *testing.T) {
+	for _, tc := range []struct {
+		entries []string
+		want    map[string][]string
+		err     bool
+	}{
+		{entries: []string{"&Alerts=#ops-.*,#monitoring"}, want: map[string][]string{"&alerts": {"#ops-.*", "#monitoring"}}},
+		{entries: []string{"&a=#x", "&b=#y"}, want: map[string][]string{"&a": {"#x"}, "&b": {"#y"}}},
+		{entries: []string{"&alerts"}, err: true},
+		{entries: []string{"#alerts=#ops"}, err: true},
+		{entries: []string{"&messages=#ops"}, err: true},
+		{entries: []string{"&alerts=#(ops"}, err: true},
+	} {
+		got, err := parseVirtualChannels(tc.entries)
+		if tc.err {
+			assert.Error(t, err, tc.entries)
+			continue
+		}
+
+		assert.NoError(t, err, tc.entries)
+		assert.Equal(t, tc.want, got, tc.entries)
+		assert.Equal(t, strings.ToLower(strings.Join(tc.entries, " ")), formatVirtualChannels(got))
+	}
+}
+
+func TestVirtualChannels(t *testing.T) {
+	db := newTestDB(t)
+
+	global := viper.New()
+	global.SetConfigType("toml")
+	assert.NoError(t, global.ReadConfig(strings.NewReader(`
+[mattermost.virtualchannels]
+"&Alerts" = ["#ops-.*", "#monitoring"]
+"&team" = ["-dev$"]
+`)))
+
+	u := newTestUser(global, db)
+	assert.True(t, u.isVirtualChannel("&ALERTS"))
+	assert.False(t, u.isVirtualChannel("&messages"))
+	assert.Equal(t, []string{"&alerts"}, u.virtualChannelsOf("#ops-db"))
+	assert.Equal(t, []string{"&alerts", "&team"}, u.virtualChannelsOf("#monitoring-dev"))
+	assert.Empty(t, u.virtualChannelsOf("#town-square"))
+
+	channels := u.virtualChannels()
+	delete(channels, "&team")
+	assert.NoError(t, u.setSetting("virtualchannels", channels))
+	assert.Len(t, global.GetStringMapStringSlice("mattermost.virtualchannels"), 2, "the global config is unchanged")
+
+	// the next login gets them back.
+	other := newTestUser(global, db)
+	other.loadSettings()
+	assert.Equal(t, map[string][]string{"&alerts": {"#ops-.*", "#monitoring"}}, other.virtualChannels())
+	assert.Equal(t, "&alerts=#ops-.*,#monitoring", other.settingValue("virtualchannels"))
+}
+
+func TestVirtualSource(t *testing.T) {
+	u := newBridgeUser(nil, nil, viper.New(), nil)
+
+	u.rememberVirtualSource("post1", "channel1")
+	assert.Equal(t, "channel1", u.virtualSource("post1"))
+
+	for i := 0; i < maxVirtualSources; i++ {
+		u.rememberVirtualSource(strings.Repeat("x", i+1), "channel2")
+	}
+
+	u.virtualMutex.Lock()
+	defer u.virtualMutex.Unlock()
+	assert.NotContains(t, u.virtualSources, "post1", "the oldest are forgotten")
+	assert.Len(t, u.virtualSources, maxVirtualSources)
+}
@@ -2,11 +2,7 @@ package main
 
 import (
 	"crypto/tls"
-	"log"
-	"os"
-	"os/signal"
 	"sync"
-	"syscall"
 )
 
 type keypairReloader struct {
@@ -27,16 +23,7 @@ func NewKeypairReloader(certPath, keyPath string) (*keypairReloader, error) {
 		return nil, err
 	}
 	result.cert = &cert
-	go func() {
-		c := make(chan os.Signal, 1)
-		signal.Notify(c, syscall.SIGHUP)
-		for range c {
-			log.Printf("Received SIGHUP, reloading TLS certificate and key from %q and %q", certPath, keyPath)
-			if err := result.maybeReload(); err != nil {
-				log.Printf("Keeping old TLS certificate because the new one could not be loaded: %v", err)
-			}
-		}
-	}()
+	// reloaded on SIGHUP, see handleSignals.
 	return result, nil
 }
 